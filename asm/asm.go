@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -71,35 +72,42 @@ type pseudoOpData struct {
 const hiBitTerm = 1 << 16
 
 var pseudoOps = map[string]pseudoOpData{
-	".ar":      {fn: (*assembler).parseArch},
-	".arch":    {fn: (*assembler).parseArch},
-	"arch":     {fn: (*assembler).parseArch},
-	".bin":     {fn: (*assembler).parseBinaryInclude},
-	".binary":  {fn: (*assembler).parseBinaryInclude},
-	".eq":      {fn: (*assembler).parseEquate},
-	".equ":     {fn: (*assembler).parseEquate},
-	"equ":      {fn: (*assembler).parseEquate},
-	"=":        {fn: (*assembler).parseEquate},
-	".or":      {fn: (*assembler).parseOrigin},
-	".org":     {fn: (*assembler).parseOrigin},
-	"org":      {fn: (*assembler).parseOrigin},
-	".db":      {fn: (*assembler).parseData, param: 1},
-	".byte":    {fn: (*assembler).parseData, param: 1},
-	".dw":      {fn: (*assembler).parseData, param: 2},
-	".word":    {fn: (*assembler).parseData, param: 2},
-	".dd":      {fn: (*assembler).parseData, param: 4},
-	".dword":   {fn: (*assembler).parseData, param: 4},
-	".dh":      {fn: (*assembler).parseHexString},
-	".hex":     {fn: (*assembler).parseHexString},
-	"hex":      {fn: (*assembler).parseHexString},
-	".ds":      {fn: (*assembler).parseData, param: 1 | hiBitTerm},
-	".tstring": {fn: (*assembler).parseData, param: 1 | hiBitTerm},
-	".al":      {fn: (*assembler).parseAlign},
-	".align":   {fn: (*assembler).parseAlign},
-	".pad":     {fn: (*assembler).parsePadding},
-	".ex":      {fn: (*assembler).parseExport},
-	".export":  {fn: (*assembler).parseExport},
-	"exp":      {fn: (*assembler).parseExport},
+	".ar":       {fn: (*assembler).parseArch},
+	".arch":     {fn: (*assembler).parseArch},
+	"arch":      {fn: (*assembler).parseArch},
+	".bin":      {fn: (*assembler).parseBinaryInclude},
+	".binary":   {fn: (*assembler).parseBinaryInclude},
+	".eq":       {fn: (*assembler).parseEquate},
+	".equ":      {fn: (*assembler).parseEquate},
+	"equ":       {fn: (*assembler).parseEquate},
+	"=":         {fn: (*assembler).parseEquate},
+	".or":       {fn: (*assembler).parseOrigin},
+	".org":      {fn: (*assembler).parseOrigin},
+	"org":       {fn: (*assembler).parseOrigin},
+	".db":       {fn: (*assembler).parseData, param: 1},
+	".byte":     {fn: (*assembler).parseData, param: 1},
+	".dw":       {fn: (*assembler).parseData, param: 2},
+	".word":     {fn: (*assembler).parseData, param: 2},
+	".dd":       {fn: (*assembler).parseData, param: 4},
+	".dword":    {fn: (*assembler).parseData, param: 4},
+	".dh":       {fn: (*assembler).parseHexString},
+	".hex":      {fn: (*assembler).parseHexString},
+	"hex":       {fn: (*assembler).parseHexString},
+	".ds":       {fn: (*assembler).parseData, param: 1 | hiBitTerm},
+	".tstring":  {fn: (*assembler).parseData, param: 1 | hiBitTerm},
+	".al":       {fn: (*assembler).parseAlign},
+	".align":    {fn: (*assembler).parseAlign},
+	".pad":      {fn: (*assembler).parsePadding},
+	".res":      {fn: (*assembler).parseReserve},
+	".dsb":      {fn: (*assembler).parseReserve},
+	".encoding": {fn: (*assembler).parseEncoding},
+	".charmap":  {fn: (*assembler).parseCharmap},
+	".assert":   {fn: (*assembler).parseAssert},
+	".error":    {fn: (*assembler).parseMessage, param: false},
+	".warning":  {fn: (*assembler).parseMessage, param: true},
+	".ex":       {fn: (*assembler).parseExport},
+	".export":   {fn: (*assembler).parseExport},
+	"exp":       {fn: (*assembler).parseExport},
 }
 
 func init() {
@@ -108,6 +116,16 @@ func init() {
 	pseudoOps[".in"] = pseudoOpData{fn: (*assembler).parseInclude}
 	pseudoOps[".include"] = pseudoOpData{fn: (*assembler).parseInclude}
 	pseudoOps["include"] = pseudoOpData{fn: (*assembler).parseInclude}
+	pseudoOps[".repeat"] = pseudoOpData{fn: (*assembler).parseRepeat}
+	pseudoOps[".rept"] = pseudoOpData{fn: (*assembler).parseRepeat}
+	pseudoOps[".endrep"] = pseudoOpData{fn: (*assembler).parseEndRepeat}
+	pseudoOps[".endr"] = pseudoOpData{fn: (*assembler).parseEndRepeat}
+	pseudoOps[".struct"] = pseudoOpData{fn: (*assembler).parseDeclaration, param: false}
+	pseudoOps[".enum"] = pseudoOpData{fn: (*assembler).parseDeclaration, param: true}
+	pseudoOps[".endstruct"] = pseudoOpData{fn: (*assembler).parseEndDeclaration}
+	pseudoOps[".ends"] = pseudoOpData{fn: (*assembler).parseEndDeclaration}
+	pseudoOps[".endenum"] = pseudoOpData{fn: (*assembler).parseEndDeclaration}
+	pseudoOps[".ende"] = pseudoOpData{fn: (*assembler).parseEndDeclaration}
 }
 
 // A segment is a small chunk of machine code that may represent a single
@@ -125,12 +143,53 @@ type instruction struct {
 	opcode    fstring          // opcode string
 	inst      *cpu.Instruction // selected instruction data for the opcode
 	operand   operand          // parameter data for the instruction
+	relax     bool             // branch may be expanded if out of range
+	long      bool             // branch expanded to an inverted branch and JMP
 }
 
 func (i *instruction) address() int {
 	return i.addr
 }
 
+// Return true if the instruction has no addressing mode matching its
+// operand or its operand couldn't be evaluated. Failed instructions have
+// been reported as errors and are skipped by the later assembly steps.
+func (i *instruction) failed() bool {
+	return i.inst == nil || i.operand.expr != nil && !i.operand.expr.evaluated
+}
+
+// Return the number of bytes of machine code generated by the instruction.
+// An expanded branch is replaced by an inverted branch over a 3-byte JMP,
+// or by the JMP alone if the branch is unconditional.
+func (i *instruction) length() int {
+	switch {
+	case !i.long:
+		return int(i.inst.Length)
+	case i.inst.Opcode == opcodeBRA:
+		return 3
+	default:
+		return 5
+	}
+}
+
+// Opcodes used when expanding branches
+const (
+	opcodeBRA = 0x80
+	opcodeJMP = 0x4c
+)
+
+// Long-branch pseudo-instructions and the branches they expand
+var longBranches = map[string]string{
+	"JCC": "BCC",
+	"JCS": "BCS",
+	"JEQ": "BEQ",
+	"JNE": "BNE",
+	"JMI": "BMI",
+	"JPL": "BPL",
+	"JVC": "BVC",
+	"JVS": "BVS",
+}
+
 // Format a byte code string for an instruction.
 func (i *instruction) codeString() string {
 	sz := i.inst.Length - 1
@@ -168,6 +227,9 @@ type operand struct {
 	expr           *expr    // expression tree, used to resolve value
 	forceImmediate bool     // operand forces an immediate addressing mode
 	forceAbsolute  bool     // operand must use 2-byte absolute address
+	forceZeroPage  bool     // operand must use 1-byte zero-page address
+	zeroPage       bool     // address operand was resolved to the zero page
+	pinned         bool     // address operand may no longer be shortened
 }
 
 func (o *operand) getValue() int {
@@ -184,14 +246,26 @@ func (o *operand) getValue() int {
 	}
 }
 
+// Return true if the operand is an address that may be shortened to the
+// zero page but hasn't been yet.
+func (o *operand) shortenable() bool {
+	return o.expr != nil && o.expr.address && !o.zeroPage && !o.pinned &&
+		!o.forceImmediate && !o.forceAbsolute && !o.forceZeroPage
+}
+
+// Return true if the operand's value is a zero-page address.
+func (o *operand) inZeroPage() bool {
+	return o.expr.value >= 0 && o.expr.value <= 0xff
+}
+
 // Return the size of the operand in bytes.
 func (o *operand) size() int {
 	switch {
 	case o.modeGuess == cpu.IMP:
 		return 0
-	case o.forceImmediate:
+	case o.forceImmediate || o.forceZeroPage:
 		return 1
-	case o.expr.address || o.forceAbsolute || o.expr.value > 0xff || o.expr.value < -128:
+	case o.forceAbsolute || (o.expr.address && !o.zeroPage) || o.expr.value > 0xff || o.expr.value < -128:
 		return 2
 	default:
 		return 1
@@ -201,10 +275,11 @@ func (o *operand) size() int {
 // A data segment contains 1 or more expressions that are evaluated to
 // produce binary data.
 type data struct {
-	addr      int     // address assigned to the segment
-	unit      int     // unit size (1 or 2 bytes)
-	hiBitTerm bool    // terminate last char of string by setting hi bit
-	exprs     []*expr // all expressions in the data segment
+	addr      int      // address assigned to the segment
+	unit      int      // unit size (1 or 2 bytes)
+	hiBitTerm bool     // terminate last char of string by setting hi bit
+	charmap   *charmap // character encoding applied to strings
+	exprs     []*expr  // all expressions in the data segment
 }
 
 func (d *data) address() int {
@@ -267,6 +342,18 @@ func (e *export) address() int {
 	return e.addr
 }
 
+// An assertion segment contains an expression that must evaluate to a
+// non-zero value once all labels have been resolved.
+type assertion struct {
+	addr int
+	expr *expr
+	msg  string
+}
+
+func (a *assertion) address() int {
+	return a.addr
+}
+
 // An asmerror is used to keep track of errors encountered
 // during assembly.
 type asmerror struct {
@@ -274,6 +361,34 @@ type asmerror struct {
 	msg  string  // error message
 }
 
+// A srcline records a single line of source code read by the assembler,
+// along with the range of segments generated from it.
+type srcline struct {
+	line  fstring // the line as originally read from the file
+	depth int     // include nesting depth of the file containing the line
+	seg0  int     // index of the first segment generated by the line
+	seg1  int     // index one past the last segment generated by the line
+	value *expr   // value of an equate declared on the line (if any)
+}
+
+// A block of source lines between .repeat and .endrep directives
+type repeat struct {
+	line  fstring   // the .repeat directive's parameters
+	count int       // number of times to assemble the block
+	vname string    // name of the loop variable (if any)
+	body  []fstring // lines captured within the block
+	nest  int       // nesting depth of .repeat blocks within the body
+}
+
+// A .struct or .enum declaration whose members are being parsed
+type declaration struct {
+	line   fstring // the declaration's opening directive
+	enum   bool    // true for .enum, false for .struct
+	name   string  // name of the struct or enum (may be empty for .enum)
+	prefix string  // prefix applied to member names
+	value  int     // offset of the next field or value of the next member
+}
+
 // An unevaluated expression
 type uneval struct {
 	expr  *expr
@@ -295,12 +410,23 @@ type assembler struct {
 	exports     []Export            // exported addresses
 	sourceLines []SourceLine        // source code line mappings
 	files       []string            // processed files
+	srclines    []srcline           // all source lines read
+	depth       int                 // current include nesting depth
+	defs        map[string]fstring  // symbol -> line where it was defined
+	rep         *repeat             // .repeat block being captured
+	decl        *declaration        // .struct or .enum being declared
+	charmap     *charmap            // active character encoding
 	segments    []segment           // segment of machine code
+	segcode     []int               // segment index -> offset of its code
 	unevaluated []uneval            // expressions requiring evaluation
+	deferred    []uneval            // all expressions not evaluated during parsing
 	out         io.Writer           // output used for verbose output
 	verbose     bool                // verbose output
+	report      bool                // report zero-page shortening
+	relax       bool                // expand out-of-range branches
 	exprParser  exprParser          // used to parse math expressions
 	errors      []asmerror          // errors encountered during assembly
+	warnings    []asmerror          // warnings encountered during assembly
 }
 
 // An Export describes an exported address.
@@ -312,8 +438,15 @@ type Export struct {
 // Assembly contains the assembled machine code and other data associated with
 // the machine code.
 type Assembly struct {
-	Code   []byte   // Assembled machine code
-	Errors []string // Errors encountered during assembly
+	Origin   uint16   // Address of the first byte of machine code
+	Code     []byte   // Assembled machine code
+	Errors   []string // Errors encountered during assembly
+	Warnings []string // Warnings reported during assembly
+	Listing  *Listing // Assembly listing (if requested)
+	Symbols  []Symbol // Labels and constants, sorted by name
+
+	// Errors and warnings with source line context
+	Diagnostics []Diagnostic
 }
 
 // ReadFrom reads machine code from a binary input source.
@@ -333,43 +466,77 @@ func (a *Assembly) WriteTo(w io.Writer) (n int64, err error) {
 	return int64(nn), err
 }
 
+// WriteFormat saves machine code into an output writer using the requested
+// file format. All formats except raw binary preserve the origin address.
+func (a *Assembly) WriteFormat(w io.Writer, f Format) (n int64, err error) {
+	return WriteImage(w, f, []Region{{Address: a.Origin, Code: a.Code}})
+}
+
 // Option type used by the Assembly function.
 type Option uint
 
 // Options for the Assemble function.
 const (
-	Verbose Option = 1 << iota // verbose output during assembly
+	Verbose          Option = 1 << iota // verbose output during assembly
+	GenerateListing                     // generate an assembly listing
+	ReportShortening                    // report operands shortened to zero page
+	RelaxBranches                       // expand out-of-range branches
+	JSONDiagnostics                     // report diagnostics as JSON
+	ExportSymbols                       // write symbol files for other tools
+	CMOS                                // default to the 65C02 architecture
 )
 
 const defaultOrigin = 0x1000
 
 // AssembleFile reads a file containing 6502 assembly code, assembles it,
-// and produces a binary output file and a source map file.
+// and produces a binary output file and a source map file. If the
+// GenerateListing option is set, a listing file is also produced. If the
+// ExportSymbols option is set, VICE label (.lbl), plain symbol list (.sym)
+// and ld65 debug info (.dbg) files are also produced.
 func AssembleFile(path string, options Option, out io.Writer) error {
+	return AssembleFileFormat(path, FormatBinary, options, out)
+}
+
+// AssembleFileFormat behaves like AssembleFile, but it stores the machine
+// code using the requested file format instead of raw binary data. The
+// output file's extension is chosen to match the format.
+func AssembleFileFormat(path string, format Format, options Option, out io.Writer) error {
 	inFile, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer inFile.Close()
 
-	assembly, sourceMap, err := Assemble(inFile, path, defaultOrigin, out, options)
-	if err != nil {
-		for _, e := range assembly.Errors {
-			fmt.Fprintln(out, e)
+	// The JSON diagnostics must be the only output, so discard any text the
+	// assembler would otherwise write.
+	text := out
+	if (options & JSONDiagnostics) != 0 {
+		text = ioutil.Discard
+	}
+
+	assembly, sourceMap, err := Assemble(inFile, path, defaultOrigin, text, options)
+	if (options & JSONDiagnostics) != 0 {
+		if jerr := assembly.WriteDiagnosticsJSON(out); jerr != nil && err == nil {
+			err = jerr
 		}
+		out = text
+	} else {
+		assembly.WriteDiagnostics(out)
+	}
+	if err != nil {
 		return err
 	}
 
 	ext := filepath.Ext(path)
 	prefix := path[:len(path)-len(ext)]
-	binPath := prefix + ".bin"
+	binPath := prefix + format.Ext()
 	binFile, err := os.OpenFile(binPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer binFile.Close()
 
-	_, err = assembly.WriteTo(binFile)
+	_, err = assembly.WriteFormat(binFile, format)
 	if err != nil {
 		return err
 	}
@@ -386,13 +553,62 @@ func AssembleFile(path string, options Option, out io.Writer) error {
 		return err
 	}
 
-	fmt.Fprintf(out, "Assembled '%s' to produce '%s' and '%s'.\n",
+	produced := []string{binPath, mapPath}
+
+	if assembly.Listing != nil {
+		lstPath := prefix + ".lst"
+		err = writeFile(lstPath, func(w io.Writer) error {
+			_, err := assembly.Listing.WriteTo(w)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		produced = append(produced, lstPath)
+	}
+
+	if (options & ExportSymbols) != 0 {
+		symFiles := []struct {
+			ext   string
+			write func(w io.Writer) error
+		}{
+			{".lbl", assembly.WriteVICELabels},
+			{".sym", assembly.WriteSymbolList},
+			{".dbg", func(w io.Writer) error {
+				return assembly.WriteDebugInfo(w, sourceMap, binPath)
+			}},
+		}
+		for _, f := range symFiles {
+			err = writeFile(prefix+f.ext, f.write)
+			if err != nil {
+				return err
+			}
+			produced = append(produced, prefix+f.ext)
+		}
+	}
+
+	names := make([]string, len(produced))
+	for i, p := range produced {
+		names[i] = "'" + filepath.Base(p) + "'"
+	}
+	last := len(names) - 1
+	fmt.Fprintf(out, "Assembled '%s' to produce %s and %s.\n",
 		filepath.Base(path),
-		filepath.Base(binPath),
-		filepath.Base(mapPath))
+		strings.Join(names[:last], ", "),
+		names[last])
 	return nil
 }
 
+// Create a file and fill it using the provided write function.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return write(file)
+}
+
 // Assemble reads data from the provided stream and attempts to assemble it
 // into 6502 byte code.
 func Assemble(r io.Reader, filename string, origin uint16, out io.Writer, options Option) (*Assembly, *SourceMap, error) {
@@ -400,19 +616,27 @@ func Assemble(r io.Reader, filename string, origin uint16, out io.Writer, option
 		out = os.Stdout
 	}
 
+	arch := cpu.NMOS
+	if options&CMOS != 0 {
+		arch = cpu.CMOS
+	}
+
 	a := &assembler{
-		arch:      cpu.NMOS,
-		instSet:   cpu.GetInstructionSet(cpu.NMOS),
+		arch:      arch,
+		instSet:   cpu.GetInstructionSet(arch),
 		origin:    int(origin),
 		pc:        -1,
 		r:         r,
 		constants: make(map[string]*expr),
 		labels:    make(map[string]int),
+		defs:      make(map[string]fstring),
 		files:     []string{filename},
 		exports:   make([]Export, 0),
 		segments:  make([]segment, 0, 32),
 		out:       out,
 		verbose:   (options & Verbose) != 0,
+		report:    (options & ReportShortening) != 0,
+		relax:     (options & RelaxBranches) != 0,
 	}
 
 	// Assembly consists of the following steps
@@ -423,33 +647,64 @@ func Assemble(r io.Reader, filename string, origin uint16, out io.Writer, option
 		(*assembler).resolveLabels,                // Resolve labels to addresses
 		(*assembler).evaluateExpressions,          // Do another evaluation pass with resolved labels
 		(*assembler).handleUnevaluatedExpressions, // Cause error if there are unevaluated expressions
-		(*assembler).generateCode,                 // Generate the machine code
+		(*assembler).optimizeAddresses,            // Shorten operands that resolve to the zero page
+		(*assembler).checkSegments,                // Check branch ranges, assertions and exports
 	}
 
-	// Execute assembler steps, breaking if an error is encountered
-	// in any one of them.
+	// Execute assembler steps. Errors in the source don't stop assembly
+	// until code is about to be generated, so that every error is reported
+	// at once; the lines that failed are skipped by the later steps.
 	var err error
 	for _, step := range steps {
-		err = step(a)
-		if err != nil {
-			break
-		}
-		if len(a.errors) > 0 {
-			err = errParse
+		if err = step(a); err != nil && err != errParse {
 			break
 		}
 	}
+	switch {
+	case err != nil && err != errParse:
+	case len(a.errors) > 0:
+		err = errParse
+	default:
+		err = a.generateCode()
+	}
+
+	// Errors found by different steps are reported by file and line.
+	sort.SliceStable(a.errors, func(i, j int) bool {
+		li, lj := a.errors[i].line, a.errors[j].line
+		if li.fileIndex != lj.fileIndex {
+			return li.fileIndex < lj.fileIndex
+		}
+		return li.row < lj.row
+	})
 
+	var diags []Diagnostic
 	errors := make([]string, 0, len(a.errors))
 	for _, e := range a.errors {
-		filename := a.files[e.line.fileIndex]
-		s := fmt.Sprintf("Syntax error in '%s' line %d, col %d: %s", filename, e.line.row, e.line.column+1, e.msg)
-		errors = append(errors, s)
+		d := a.diagnostic(SeverityError, e)
+		diags = append(diags, d)
+		errors = append(errors, d.String())
+	}
+
+	warnings := make([]string, 0, len(a.warnings))
+	for _, w := range a.warnings {
+		d := a.diagnostic(SeverityWarning, w)
+		diags = append(diags, d)
+		warnings = append(warnings, d.String())
 	}
 
 	assembly := &Assembly{
-		Code:   a.code,
-		Errors: errors,
+		Origin:      uint16(a.origin),
+		Code:        a.code,
+		Errors:      errors,
+		Warnings:    warnings,
+		Diagnostics: diags,
+	}
+
+	if err == nil {
+		assembly.Symbols = a.symbols()
+		if (options & GenerateListing) != 0 {
+			assembly.Listing = a.buildListing()
+		}
 	}
 
 	sourceMap := &SourceMap{
@@ -475,6 +730,15 @@ func (a *assembler) parse() error {
 		return err
 	}
 
+	if a.rep != nil {
+		a.addError(a.rep.line, "'.repeat' without matching '.endrep'")
+		return errParse
+	}
+	if a.decl != nil {
+		a.addError(a.decl.line, "declaration without matching end directive")
+		return errParse
+	}
+
 	// Add an empty byte-data segment to the end of the file, just so the
 	// end of the file can be assigned an address and any labels attached
 	// to the end of the file will be valid.
@@ -492,8 +756,15 @@ func (a *assembler) parseFile(scanner *bufio.Scanner, fileIndex int) error {
 	for scanner.Scan() {
 		text := scanner.Text()
 		line := newFstring(fileIndex, row, text)
-		err := a.parseLine(line.stripTrailingComment())
-		if err != nil {
+
+		// Keep parsing after a syntax error so that all errors in the
+		// file are reported together.
+		nerrors := len(a.errors)
+		err := a.parseSourceLine(line)
+		switch {
+		case err == errParse && len(a.errors) == nerrors:
+			a.addError(line, "syntax error")
+		case err != nil && err != errParse:
 			return err
 		}
 		row++
@@ -501,9 +772,105 @@ func (a *assembler) parseFile(scanner *bufio.Scanner, fileIndex int) error {
 	return nil
 }
 
+// Parse a single line of source code and record it for the listing. While
+// a .repeat block is open, lines are captured instead of parsed.
+func (a *assembler) parseSourceLine(line fstring) error {
+	if a.rep != nil {
+		return a.captureRepeatLine(line)
+	}
+
+	n := len(a.srclines)
+	a.srclines = append(a.srclines, srcline{line: line, depth: a.depth, seg0: len(a.segments)})
+
+	var err error
+	if a.decl != nil {
+		err = a.parseMember(line.stripTrailingComment())
+	} else {
+		err = a.parseLine(line.stripTrailingComment())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Segments generated by an included file or a repeated block belong to
+	// the nested lines, not to the line containing the directive.
+	a.srclines[n].seg1 = len(a.segments)
+	if len(a.srclines) > n+1 {
+		a.srclines[n].seg1 = a.srclines[n].seg0
+	}
+	return nil
+}
+
+// Capture a line belonging to the open .repeat block. When the block's
+// closing .endrep is reached, the block is assembled.
+func (a *assembler) captureRepeatLine(line fstring) error {
+	r := a.rep
+	switch repeatDirective(line) {
+	case ".repeat", ".rept":
+		r.nest++
+	case ".endrep", ".endr":
+		if r.nest == 0 {
+			a.rep = nil
+			err := a.expandRepeat(r)
+			seg := len(a.segments)
+			a.srclines = append(a.srclines, srcline{line: line, depth: a.depth, seg0: seg, seg1: seg})
+			return err
+		}
+		r.nest--
+	}
+	r.body = append(r.body, line)
+	return nil
+}
+
+// Return the lower-cased directive or opcode appearing on a line.
+func repeatDirective(line fstring) string {
+	line = line.stripTrailingComment()
+	if !line.startsWith(whitespace) {
+		_, line = line.consumeWhile(labelChar)
+		if line.startsWithChar(':') {
+			line = line.consume(1)
+		}
+	}
+	line = line.consumeWhitespace()
+	word, _ := line.consumeWhile(wordChar)
+	return strings.ToLower(word.str)
+}
+
+// Assemble the lines of a .repeat block once for each repetition, updating
+// the loop variable before each pass.
+func (a *assembler) expandRepeat(r *repeat) error {
+	if r.vname != "" {
+		if a.exprParser.vars == nil {
+			a.exprParser.vars = make(map[string]int)
+		}
+		prev, shadowed := a.exprParser.vars[r.vname]
+		defer func() {
+			if shadowed {
+				a.exprParser.vars[r.vname] = prev
+			} else {
+				delete(a.exprParser.vars, r.vname)
+			}
+		}()
+	}
+
+	for i := 0; i < r.count; i++ {
+		if r.vname != "" {
+			a.exprParser.vars[r.vname] = i
+		}
+		for _, line := range r.body {
+			if err := a.parseSourceLine(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Add an expression to the "unevaluated" list.
 func (a *assembler) pushUnevaluated(e *expr) {
-	a.unevaluated = append(a.unevaluated, uneval{expr: e, segno: len(a.segments)})
+	u := uneval{expr: e, segno: len(a.segments)}
+	a.unevaluated = append(a.unevaluated, u)
+	a.deferred = append(a.deferred, u)
 }
 
 // Return the address assigned to the requested segment.
@@ -539,14 +906,24 @@ func (a *assembler) evaluateExpressions() error {
 func (a *assembler) assignAddresses() error {
 	a.logSection("Assigning addresses")
 	a.pc = a.origin
+	var err error
 	for _, s := range a.segments {
 		switch ss := s.(type) {
 		case *instruction:
 			ss.addr = a.pc
 			ss.inst = a.findMatchingInstruction(ss.opcode, ss.operand)
+			if ss.inst == nil && ss.operand.shortenable() {
+				// Some addressing modes exist only in zero-page form, so
+				// assume the address is in the zero page and leave it to
+				// the optimizer to check once the address is known.
+				ss.operand.zeroPage = true
+				ss.inst = a.findMatchingInstruction(ss.opcode, ss.operand)
+				ss.operand.zeroPage = ss.inst != nil
+			}
 			if ss.inst == nil {
 				a.addError(ss.opcode, "invalid addressing mode for opcode '%s'", ss.opcode.str)
-				return errParse
+				err = errParse
+				continue
 			}
 
 			l := SourceLine{
@@ -556,10 +933,16 @@ func (a *assembler) assignAddresses() error {
 			}
 			a.sourceLines = append(a.sourceLines, l)
 
+			// The JMP of an expanded branch maps to the same source line.
+			if ss.long && ss.inst.Opcode != opcodeBRA {
+				l.Address += 2
+				a.sourceLines = append(a.sourceLines, l)
+			}
+
 			a.log("%04X  %s Len:%d Mode:%s Opcode:%02X",
-				ss.addr, ss.opcode.str, ss.inst.Length,
+				ss.addr, ss.opcode.str, ss.length(),
 				modeName[ss.inst.Mode], ss.inst.Opcode)
-			a.pc += int(ss.inst.Length)
+			a.pc += ss.length()
 
 		case *data:
 			ss.addr = a.pc
@@ -585,11 +968,12 @@ func (a *assembler) assignAddresses() error {
 				a.evaluateExpressions()
 				if !ss.valExpr.evaluated {
 					a.addError(ss.valExpr.line, "padding value expression could not be evaluated")
-					return errParse
+					err = errParse
 				}
 				if !ss.lenExpr.evaluated {
 					a.addError(ss.lenExpr.line, "padding length expression could not be evaluated")
-					return errParse
+					err = errParse
+					continue
 				}
 			}
 			ss.value = byte(ss.valExpr.value)
@@ -599,9 +983,12 @@ func (a *assembler) assignAddresses() error {
 
 		case *export:
 			ss.addr = a.pc
+
+		case *assertion:
+			ss.addr = a.pc
 		}
 	}
-	return nil
+	return err
 }
 
 // Resolve all labels to addresses.
@@ -614,7 +1001,7 @@ func (a *assembler) resolveLabels() error {
 		addr := a.segaddr(segno)
 		if addr != -1 {
 			a.log("%-15s Seg:%-3d Addr:$%04X", label, segno, addr)
-			a.constants[label] = &expr{op: opNumber, value: addr, evaluated: true}
+			a.constants[label] = &expr{op: opNumber, value: addr, address: true, evaluated: true}
 		}
 	}
 	return nil
@@ -631,21 +1018,168 @@ func (a *assembler) handleUnevaluatedExpressions() error {
 	return nil
 }
 
+// Shorten instructions whose address operands resolve to the zero page,
+// and expand relaxable branches whose targets are out of range. Because
+// resizing an instruction moves every label that follows it, addresses are
+// reassigned and expressions re-evaluated until the instruction sizes no
+// longer change. An operand that must grow again after being shortened is
+// pinned to absolute addressing, and expanded branches are never shrunk,
+// so the process terminates.
+func (a *assembler) optimizeAddresses() error {
+	a.logSection("Optimizing addresses")
+
+	for {
+		changed := false
+		for _, s := range a.segments {
+			i, ok := s.(*instruction)
+			if !ok || i.operand.expr == nil || i.failed() {
+				continue
+			}
+			if i.inst.Mode == cpu.REL {
+				if i.relax && !i.long {
+					if _, err := relOffset(i.operand.getValue(), i.addr+int(i.inst.Length)); err != nil {
+						i.long, changed = true, true
+					}
+				}
+				continue
+			}
+			if !i.operand.expr.address {
+				continue
+			}
+			o := &i.operand
+			if o.forceImmediate || o.forceAbsolute || o.forceZeroPage {
+				continue
+			}
+			fits := o.inZeroPage()
+			switch {
+			case fits && !o.zeroPage && !o.pinned:
+				o.zeroPage, changed = true, true
+			case !fits && o.zeroPage:
+				o.zeroPage, o.pinned, changed = false, true, true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		// Forget all label addresses and re-evaluate every expression that
+		// depends on them.
+		for label := range a.labels {
+			if e, ok := a.constants[label]; ok && e.op == opNumber && e.address {
+				delete(a.constants, label)
+			}
+		}
+		for _, u := range a.deferred {
+			u.expr.reset()
+		}
+		a.unevaluated = append([]uneval{}, a.deferred...)
+		a.sourceLines = nil
+
+		steps := []func(a *assembler) error{
+			(*assembler).assignAddresses,
+			(*assembler).resolveLabels,
+			(*assembler).evaluateExpressions,
+			(*assembler).handleUnevaluatedExpressions,
+		}
+		for _, step := range steps {
+			if err := step(a); err != nil && err != errParse {
+				return err
+			}
+		}
+	}
+
+	for _, s := range a.segments {
+		i, ok := s.(*instruction)
+		if !ok || i.operand.expr == nil || i.failed() {
+			continue
+		}
+		o := &i.operand
+		if o.forceZeroPage && !o.inZeroPage() {
+			continue
+		}
+		if a.report && i.inst.Mode != cpu.REL {
+			switch {
+			case o.zeroPage && i.inst.Length == 2:
+				a.reportShortening(i, "shortened to zero page")
+			case o.forceAbsolute && o.modeGuess != cpu.IMM && o.inZeroPage() && a.hasZeroPageForm(i):
+				a.reportShortening(i, "could be shortened by removing absolute prefix")
+			}
+		}
+	}
+	return nil
+}
+
+// Return true if the instruction has a zero-page form matching its
+// operand.
+func (a *assembler) hasZeroPageForm(i *instruction) bool {
+	o := i.operand
+	o.forceAbsolute, o.forceZeroPage = false, true
+	inst := a.findMatchingInstruction(i.opcode, o)
+	return inst != nil && inst.Length == 2
+}
+
+// Write a line to the zero-page shortening report.
+func (a *assembler) reportShortening(i *instruction, msg string) {
+	fmt.Fprintf(a.out, "%s line %d: %s %s (%s)\n", a.files[i.fileIndex], i.line, i.opcode.str, i.operandString(), msg)
+}
+
+// Report operands that can't be encoded, failed assertions and exports
+// that aren't labels. Segments whose expressions couldn't be evaluated
+// have already been reported and are skipped.
+func (a *assembler) checkSegments() error {
+	a.logSection("Checking segments")
+	for _, s := range a.segments {
+		switch ss := s.(type) {
+		case *instruction:
+			if ss.operand.expr == nil || ss.failed() {
+				continue
+			}
+			o := &ss.operand
+			switch {
+			case o.forceZeroPage && !o.inZeroPage():
+				a.addError(o.expr.line, "zero-page operand out of range")
+			case ss.inst.Mode == cpu.REL && !ss.long:
+				if _, err := relOffset(o.getValue(), ss.addr+int(ss.inst.Length)); err != nil {
+					a.addError(ss.opcode, "branch offset out of bounds")
+				}
+			}
+
+		case *assertion:
+			if ss.expr.evaluated && ss.expr.value == 0 {
+				a.addError(ss.expr.line, "%s", ss.msg)
+			}
+
+		case *export:
+			if ss.expr.evaluated && (ss.expr.op != opIdentifier || !ss.expr.address) {
+				a.addError(ss.expr.line, "export is not an address label")
+			}
+		}
+	}
+	return nil
+}
+
 // Generate machine code.
 func (a *assembler) generateCode() error {
 	a.logSection("Generating code")
+	a.segcode = make([]int, 0, len(a.segments)+1)
 	for _, s := range a.segments {
+		a.segcode = append(a.segcode, len(a.code))
 		switch ss := s.(type) {
 		case *instruction:
 			a.code = append(a.code, ss.inst.Opcode)
 			switch {
 			case ss.inst.Length == 1:
 				a.log("%04X-   %-8s    %s", ss.addr, ss.codeString(), ss.opcode.str)
-			case ss.inst.Mode == cpu.REL:
-				offset, err := relOffset(ss.operand.getValue(), ss.addr+int(ss.inst.Length))
-				if err != nil {
-					a.addError(ss.opcode, "branch offset out of bounds")
+			case ss.long:
+				a.code = a.code[:len(a.code)-1]
+				if ss.inst.Opcode != opcodeBRA {
+					a.code = append(a.code, ss.inst.Opcode^0x20, 3)
 				}
+				a.code = append(a.code, opcodeJMP)
+				a.code = append(a.code, toBytes(2, ss.operand.getValue())...)
+				a.log("%04X-   %-8s    %s   $%04X (expanded)", ss.addr, byteString(a.code[a.segcode[len(a.segcode)-1]:]), ss.opcode.str, ss.operand.getValue())
+			case ss.inst.Mode == cpu.REL:
+				offset, _ := relOffset(ss.operand.getValue(), ss.addr+int(ss.inst.Length))
 				a.code = append(a.code, offset)
 				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
 			case ss.inst.Length == 2:
@@ -663,7 +1197,7 @@ func (a *assembler) generateCode() error {
 			for _, e := range ss.exprs {
 				switch {
 				case e.isString:
-					s := []byte(e.stringLiteral.str)
+					s := ss.charmap.encode(e.stringLiteral.str)
 					if ss.hiBitTerm && len(s) > 0 {
 						s[len(s)-1] = s[len(s)-1] | 0x80
 					}
@@ -692,9 +1226,6 @@ func (a *assembler) generateCode() error {
 			a.logBytes(ss.addr, pad)
 
 		case *export:
-			if ss.expr.op != opIdentifier || !ss.expr.address {
-				a.addError(ss.expr.line, "export is not an address label")
-			}
 			export := Export{
 				Label:   ss.expr.identifier.str,
 				Address: uint16(ss.expr.value),
@@ -702,6 +1233,7 @@ func (a *assembler) generateCode() error {
 			a.exports = append(a.exports, export)
 		}
 	}
+	a.segcode = append(a.segcode, len(a.code))
 	return nil
 }
 
@@ -780,6 +1312,7 @@ func (a *assembler) storeLabel(label fstring) error {
 	// Associate the label with its segment number.
 	segno := len(a.segments)
 	a.labels[label.str] = segno
+	a.defs[label.str] = label
 	a.logLine(label, "label=%s", label.str)
 	a.logLine(label, "seg=%d", segno)
 	return nil
@@ -861,9 +1394,61 @@ func (a *assembler) parseEquate(line, label fstring, param any) error {
 
 	// Track the constants for later substitution.
 	a.constants[label.str] = e
+	a.defs[label.str] = label
+	if n := len(a.srclines); n > 0 {
+		a.srclines[n-1].value = e
+	}
+	return nil
+}
+
+// Parse a ".REPEAT count [, var]" directive, which opens a block of lines
+// to be assembled repeatedly.
+func (a *assembler) parseRepeat(line, label fstring, param any) error {
+	if label.str != "" {
+		if err := a.storeLabel(label); err != nil {
+			return err
+		}
+	}
+
+	countStr, remain := line.consumeUntilChar(',')
+	e, _, err := a.exprParser.parse(countStr, a.scopeLabel, allowParentheses)
+	if err != nil {
+		a.addExprErrors()
+		return err
+	}
+	if !e.eval(-1, a.constants, a.labels) {
+		a.addError(line, "repeat count must be a constant expression")
+		return errParse
+	}
+	if e.value < 0 || e.value > 0xffff {
+		a.addError(line, "invalid repeat count %d", e.value)
+		return errParse
+	}
+
+	r := &repeat{line: line, count: e.value}
+	if !remain.isEmpty() {
+		remain = remain.consume(1).consumeWhitespace()
+		vname, rest := remain.consumeWhile(labelChar)
+		rest = rest.consumeWhitespace()
+		if vname.isEmpty() || !vname.startsWith(identifierStartChar) || !rest.isEmpty() {
+			a.addError(remain, "invalid repeat variable")
+			return errParse
+		}
+		r.vname = vname.str
+	}
+
+	a.logLine(line, "repeat=%d", r.count)
+	a.rep = r
 	return nil
 }
 
+// Parse an ".ENDREP" directive. Matching directives are consumed while the
+// .repeat block is captured, so this is reached only for unmatched ones.
+func (a *assembler) parseEndRepeat(line, label fstring, param any) error {
+	a.addError(line, "'.endrep' without matching '.repeat'")
+	return errParse
+}
+
 // Parse an ".ORG" origin definition
 func (a *assembler) parseOrigin(line, label fstring, param any) error {
 	if len(a.segments) > 0 {
@@ -898,6 +1483,7 @@ func (a *assembler) parseData(line, label fstring, param any) error {
 	seg := &data{
 		unit:      param.(int) & 7,
 		hiBitTerm: (param.(int) & hiBitTerm) != 0,
+		charmap:   a.charmap,
 		addr:      -1,
 	}
 
@@ -934,6 +1520,81 @@ func (a *assembler) parseData(line, label fstring, param any) error {
 	return nil
 }
 
+// Parse an ".ENCODING name" pseudo-op, which selects the character
+// encoding used by subsequent string and character literals.
+func (a *assembler) parseEncoding(line, label fstring, param any) error {
+	name, _ := line.consumeWhile(labelChar)
+	m, ok := charmaps[strings.ToLower(name.str)]
+	if !ok {
+		a.addError(line, "unknown encoding '%s'", name.str)
+		return errParse
+	}
+
+	a.logLine(line, "encoding=%s", name.str)
+	a.setCharmap(m)
+	return nil
+}
+
+// Parse a ".CHARMAP char, code [, count]" pseudo-op, which changes the
+// encoding of one or more consecutive characters in the active encoding.
+func (a *assembler) parseCharmap(line, label fstring, param any) error {
+	// Character literals on this line name source characters, so they must
+	// not be encoded.
+	a.exprParser.charmap = nil
+	defer func() { a.exprParser.charmap = a.charmap }()
+
+	var values []int
+	remain := line
+	for !remain.isEmpty() {
+		var s fstring
+		s, remain = remain.consumeUntilUnquotedChar(',')
+		if !remain.isEmpty() {
+			remain = remain.consume(1).consumeWhitespace()
+		}
+
+		e, _, err := a.exprParser.parse(s, a.scopeLabel, allowParentheses)
+		if err != nil {
+			a.addExprErrors()
+			return err
+		}
+		if !e.eval(-1, a.constants, a.labels) {
+			a.addError(s, "charmap value must be a constant expression")
+			return errParse
+		}
+		values = append(values, e.value)
+	}
+
+	if len(values) < 2 || len(values) > 3 {
+		a.addError(line, "charmap requires a character, a code and an optional count")
+		return errParse
+	}
+	from, to, count := values[0], values[1], 1
+	if len(values) == 3 {
+		count = values[2]
+	}
+	if from < 0 || to < 0 || count < 1 || from+count > 256 || to+count > 256 {
+		a.addError(line, "charmap value out of range")
+		return errParse
+	}
+
+	m := charmaps["ascii"]
+	if a.charmap != nil {
+		m = a.charmap
+	}
+	mm := *m
+	for i := 0; i < count; i++ {
+		mm[from+i] = byte(to + i)
+	}
+	a.setCharmap(&mm)
+	return nil
+}
+
+// Select the character encoding used by string and character literals.
+func (a *assembler) setCharmap(m *charmap) {
+	a.charmap = m
+	a.exprParser.charmap = m
+}
+
 // Parse a hex-string pseudo-op.
 func (a *assembler) parseHexString(line, label fstring, param any) error {
 	a.logLine(line, "hexstring=")
@@ -1043,6 +1704,200 @@ func (a *assembler) parsePadding(line, label fstring, param any) error {
 	return nil
 }
 
+// Parse a ".RES count [, fill]" pseudo-op, which reserves a block of
+// memory filled with an optional value.
+func (a *assembler) parseReserve(line, label fstring, param any) error {
+	a.logLine(line, "reserve=")
+
+	if !label.isEmpty() {
+		err := a.storeLabel(label)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, remain := line.consumeUntilChar(',')
+	lenExpr, _, err := a.exprParser.parse(s, a.scopeLabel, allowParentheses)
+	if err != nil {
+		a.addExprErrors()
+		return err
+	}
+	if !lenExpr.eval(-1, a.constants, a.labels) {
+		a.pushUnevaluated(lenExpr)
+	}
+
+	valExpr := &expr{op: opNumber, bytes: 1, evaluated: true}
+	if !remain.isEmpty() {
+		s = remain.consume(1).consumeWhitespace()
+		valExpr, _, err = a.exprParser.parse(s, a.scopeLabel, allowParentheses)
+		if err != nil {
+			a.addExprErrors()
+			return err
+		}
+		if !valExpr.eval(-1, a.constants, a.labels) {
+			a.pushUnevaluated(valExpr)
+		}
+	}
+
+	a.logLine(line, "lenexpr=%s", lenExpr.String())
+	seg := &padding{addr: -1, valExpr: valExpr, lenExpr: lenExpr}
+	a.segments = append(a.segments, seg)
+	return nil
+}
+
+// Parse a ".STRUCT name" or ".ENUM [name]" pseudo-op, which begins a
+// declaration whose members are defined on the lines that follow.
+func (a *assembler) parseDeclaration(line, label fstring, param any) error {
+	d := &declaration{line: line, enum: param.(bool)}
+
+	name := label
+	if name.isEmpty() {
+		name, line = line.consumeWhile(labelChar)
+	}
+	d.name = name.str
+
+	switch {
+	case d.name != "":
+		d.prefix = d.name + "."
+	case !d.enum:
+		a.addError(line, "struct declaration requires a name")
+		return errParse
+	}
+
+	a.logLine(line, "declaration=%s", d.name)
+	a.decl = d
+	return nil
+}
+
+// Parse a declaration end pseudo-op. Matching directives are consumed
+// while members are parsed, so this is reached only for unmatched ones.
+func (a *assembler) parseEndDeclaration(line, label fstring, param any) error {
+	a.addError(line, "end of declaration without matching '.struct' or '.enum'")
+	return errParse
+}
+
+// Parse a line within a .struct or .enum declaration.
+func (a *assembler) parseMember(line fstring) error {
+	if line.isEmpty() || line.startsWithChar('*') {
+		return nil
+	}
+
+	var label fstring
+	if !line.startsWith(whitespace) {
+		var err error
+		label, line, err = a.parseLabel(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	line = line.consumeWhitespace()
+	word, line := line.consumeWhile(wordChar)
+	line = line.consumeWhitespace()
+	directive := strings.ToLower(word.str)
+
+	d := a.decl
+	switch {
+	case !d.enum && (directive == ".endstruct" || directive == ".ends"):
+		a.decl = nil
+		return a.defineConstant(word, d.prefix+"sizeof", d.value)
+	case d.enum && (directive == ".endenum" || directive == ".ende"):
+		a.decl = nil
+		return nil
+	case label.isEmpty() && d.enum:
+		a.addError(word, "enum member requires a name")
+		return errParse
+	}
+
+	if d.enum {
+		switch directive {
+		case "":
+		case "=", ".eq", ".equ", "equ":
+			v, err := a.parseConstantExpr(line)
+			if err != nil {
+				return err
+			}
+			d.value = v
+		default:
+			a.addError(word, "invalid enum member")
+			return errParse
+		}
+		err := a.defineConstant(label, d.prefix+label.str, d.value)
+		d.value++
+		return err
+	}
+
+	// Determine the size of the struct field.
+	var size int
+	switch directive {
+	case ".db", ".byte", ".dw", ".word", ".dd", ".dword":
+		count := 1
+		if !line.isEmpty() {
+			var err error
+			if count, err = a.parseConstantExpr(line); err != nil {
+				return err
+			}
+		}
+		size = count * pseudoOps[directive].param.(int)
+	case ".res", ".dsb":
+		var err error
+		if size, err = a.parseConstantExpr(line); err != nil {
+			return err
+		}
+	case ".tag":
+		name, _ := line.consumeWhile(labelChar)
+		e, ok := a.constants[name.str+".sizeof"]
+		if !ok {
+			a.addError(line, "unknown struct '%s'", name.str)
+			return errParse
+		}
+		size = e.value
+	default:
+		a.addError(word, "invalid struct field")
+		return errParse
+	}
+
+	if !label.isEmpty() {
+		if err := a.defineConstant(label, d.prefix+label.str, d.value); err != nil {
+			return err
+		}
+	}
+	d.value += size
+	return nil
+}
+
+// Parse an expression that must be evaluated immediately and return its
+// value.
+func (a *assembler) parseConstantExpr(line fstring) (int, error) {
+	e, _, err := a.exprParser.parse(line, a.scopeLabel, allowParentheses)
+	if err != nil {
+		a.addExprErrors()
+		return 0, err
+	}
+	if !e.eval(-1, a.constants, a.labels) {
+		a.addError(line, "expression must be constant")
+		return 0, errParse
+	}
+	return e.value, nil
+}
+
+// Define a constant with a known value.
+func (a *assembler) defineConstant(def fstring, name string, value int) error {
+	if _, found := a.constants[name]; found {
+		a.addError(def, "symbol '%s' defined more than once", name)
+		return errParse
+	}
+
+	e := &expr{op: opNumber, value: value, bytes: valueBytes(value), evaluated: true}
+	a.constants[name] = e
+	a.defs[name] = def
+	if n := len(a.srclines); n > 0 && !def.isEmpty() {
+		a.srclines[n-1].value = e
+	}
+	a.logLine(def, "const %s=$%X", name, value)
+	return nil
+}
+
 // Parse an export pseudo-op
 func (a *assembler) parseExport(line, label fstring, param any) error {
 	a.logLine(line, "export=")
@@ -1072,6 +1927,66 @@ func (a *assembler) parseExport(line, label fstring, param any) error {
 	return nil
 }
 
+// Parse an ".ASSERT expr [, message]" pseudo-op. The expression is checked
+// after all labels have been resolved.
+func (a *assembler) parseAssert(line, label fstring, param any) error {
+	a.logLine(line, "assert=")
+
+	s, remain := line.consumeUntilUnquotedChar(',')
+	e, _, err := a.exprParser.parse(s, a.scopeLabel, allowParentheses)
+	if err != nil {
+		a.addExprErrors()
+		return err
+	}
+
+	if !e.eval(-1, a.constants, a.labels) {
+		a.pushUnevaluated(e)
+	}
+
+	msg := "assertion failed"
+	if !remain.isEmpty() {
+		text, err := a.parseString(remain.consume(1).consumeWhitespace())
+		if err != nil {
+			return err
+		}
+		msg += ": " + text
+	}
+
+	seg := &assertion{addr: -1, expr: e, msg: msg}
+	a.segments = append(a.segments, seg)
+	return nil
+}
+
+// Parse an ".ERROR" or ".WARNING" pseudo-op, which reports a message
+// during assembly. A warning does not cause the assembly to fail.
+func (a *assembler) parseMessage(line, label fstring, param any) error {
+	msg, err := a.parseString(line)
+	if err != nil {
+		return err
+	}
+
+	if param.(bool) {
+		a.addWarning(line, "%s", msg)
+		return nil
+	}
+	a.addError(line, "%s", msg)
+	return errParse
+}
+
+// Parse a quoted string literal.
+func (a *assembler) parseString(line fstring) (string, error) {
+	e, _, err := a.exprParser.parse(line, a.scopeLabel, allowStrings)
+	if err != nil {
+		a.addExprErrors()
+		return "", err
+	}
+	if !e.isString {
+		a.addError(line, "expected a quoted string")
+		return "", errParse
+	}
+	return e.stringLiteral.str, nil
+}
+
 // Parse an include pseudo-op
 func (a *assembler) parseInclude(line, label fstring, param any) error {
 	a.logLine(line, "include")
@@ -1092,6 +2007,9 @@ func (a *assembler) parseInclude(line, label fstring, param any) error {
 	fileIndex := len(a.files)
 	a.files = append(a.files, filename.str)
 
+	a.depth++
+	defer func() { a.depth-- }()
+
 	return a.parseFile(bufio.NewScanner(file), fileIndex)
 }
 
@@ -1141,6 +2059,12 @@ func (a *assembler) parseInstruction(opcode, remain fstring) error {
 		return errParse
 	}
 
+	// Long-branch pseudo-instructions are assembled as relaxable branches.
+	relax := a.relax
+	if branch, ok := longBranches[strings.ToUpper(opcode.str)]; ok {
+		opcode.str, relax = branch, true
+	}
+
 	// Validate the opcode
 	instructions := a.instSet.GetInstructions(opcode.str)
 	if instructions == nil {
@@ -1164,6 +2088,7 @@ func (a *assembler) parseInstruction(opcode, remain fstring) error {
 		line:      remain.row,
 		opcode:    opcode,
 		operand:   operand,
+		relax:     relax,
 	}
 	a.segments = append(a.segments, seg)
 	return nil
@@ -1178,7 +2103,8 @@ func (a *assembler) parseOperand(line fstring) (o operand, remain fstring, err e
 
 	case line.startsWithChar('('):
 		var expr fstring
-		o.modeGuess, expr, remain, err = line.consume(1).consumeIndirect()
+		line = o.consumeSizePrefix(line.consume(1))
+		o.modeGuess, expr, remain, err = line.consumeIndirect()
 		if err != nil {
 			a.addError(remain, "unknown addressing mode format")
 			return
@@ -1198,14 +2124,9 @@ func (a *assembler) parseOperand(line fstring) (o operand, remain fstring, err e
 			return
 		}
 
-	case line.startsWithChar('A') && (line.startsWithString("A:") || line.startsWithString("ABS:")):
-		o.forceAbsolute = true
-		_, line = line.consumeUntilChar(':')
-		line = line.consume(1)
-		fallthrough
-
 	default:
 		var expr fstring
+		line = o.consumeSizePrefix(line)
 		o.modeGuess, expr, remain, err = line.consumeAbsolute()
 		if err != nil {
 			a.addError(remain, "unknown addressing mode format")
@@ -1241,9 +2162,31 @@ func (a *assembler) parseOperand(line fstring) (o operand, remain fstring, err e
 	return
 }
 
+// Consume an optional "A:"/"ABS:" or "Z:"/"ZP:" prefix that forces an
+// address operand to be absolute or zero-page.
+func (o *operand) consumeSizePrefix(line fstring) fstring {
+	switch {
+	case line.startsWithString("A:") || line.startsWithString("ABS:"):
+		o.forceAbsolute = true
+	case line.startsWithString("Z:") || line.startsWithString("ZP:"):
+		o.forceZeroPage = true
+	default:
+		return line
+	}
+	_, line = line.consumeUntilChar(':')
+	return line.consume(1)
+}
+
 // Append an error message to the assembler's error state.
 func (a *assembler) addError(l fstring, format string, args ...any) {
 	msg := fmt.Sprintf(format, args...)
+
+	// Steps repeated by the optimizer may find the same error again.
+	for _, e := range a.errors {
+		if e.msg == msg && e.line.fileIndex == l.fileIndex && e.line.row == l.row && e.line.column == l.column {
+			return
+		}
+	}
 	a.errors = append(a.errors, asmerror{l, msg})
 	if a.verbose {
 		filename := a.files[l.fileIndex]
@@ -1256,6 +2199,16 @@ func (a *assembler) addError(l fstring, format string, args ...any) {
 	}
 }
 
+// Append a warning message to the assembler's warning list.
+func (a *assembler) addWarning(l fstring, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	a.warnings = append(a.warnings, asmerror{l, msg})
+	if a.verbose {
+		filename := a.files[l.fileIndex]
+		fmt.Fprintf(a.out, "Warning in '%s' line %d, col %d: %s\n", filename, l.row, l.column+1, msg)
+	}
+}
+
 // Append the expression parser's error to the assembler's
 // error state.
 func (a *assembler) addExprErrors() {
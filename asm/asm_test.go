@@ -6,7 +6,9 @@ package asm
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -338,3 +340,520 @@ func Test65c02FailOn6502(t *testing.T) {
 		checkASMError(t, prefix+line, "parse error")
 	}
 }
+
+func Test65c02Option(t *testing.T) {
+	src := "\t.ORG $1000\n\tPHX\n\tSTZ $01"
+	a, _, err := Assemble(strings.NewReader(src), "test", 0x1000, &bytes.Buffer{}, CMOS)
+	if err != nil || !bytes.Equal(a.Code, []byte{0xda, 0x64, 0x01}) {
+		t.Errorf("CMOS option not honored (%v)", err)
+	}
+
+	// The source may still select another architecture.
+	_, _, err = Assemble(strings.NewReader("\t.ARCH 6502\n"+src), "test", 0x1000, &bytes.Buffer{}, CMOS)
+	if err == nil {
+		t.Error("65C02 instructions assembled after .ARCH 6502")
+	}
+}
+
+func TestListing(t *testing.T) {
+	asm := `
+	.OR $1000
+FOO	.EQ $12
+START	LDA FOO
+	BNE START
+	.DB 1, 2, 3, 4, 5`
+
+	r := bytes.NewReader([]byte(asm))
+	assembly, _, err := Assemble(r, "test", 0x1000, os.Stdout, GenerateListing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := assembly.Listing
+	if l == nil || len(l.Lines) != 6 {
+		t.Fatal("listing lines missing")
+	}
+
+	if !l.Lines[2].Equate || l.Lines[2].Value != 0x12 {
+		t.Errorf("equate line incorrect: %+v", l.Lines[2])
+	}
+	if ll := l.Lines[3]; ll.Address != 0x1000 || byteString(ll.Code) != "A5 12" || ll.Cycles != 3 {
+		t.Errorf("instruction line incorrect: %+v", ll)
+	}
+	if ll := l.Lines[4]; ll.Address != 0x1002 || ll.Cycles != 2 || ll.BPCycles != 1 {
+		t.Errorf("branch line incorrect: %+v", ll)
+	}
+	if ll := l.Lines[5]; ll.Address != 0x1004 || len(ll.Code) != 5 {
+		t.Errorf("data line incorrect: %+v", ll)
+	}
+
+	if len(l.Symbols) != 2 {
+		t.Fatalf("expected 2 symbols, got %d", len(l.Symbols))
+	}
+	foo, start := l.Symbols[0], l.Symbols[1]
+	if foo.Name != "FOO" || foo.Label || foo.Line != 3 || len(foo.Refs) != 1 || foo.Refs[0].Line != 4 {
+		t.Errorf("symbol FOO incorrect: %+v", foo)
+	}
+	if start.Name != "START" || !start.Label || start.Value != 0x1000 || len(start.Refs) != 1 || start.Refs[0].Line != 5 {
+		t.Errorf("symbol START incorrect: %+v", start)
+	}
+
+	var buf bytes.Buffer
+	if _, err := l.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "1000  A5 12     3") {
+		t.Errorf("listing text incorrect:\n%s", buf.String())
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	regions := []Region{{Address: 0x1000, Code: []byte{0xa9, 0x01, 0x60}}}
+
+	tests := []struct {
+		format Format
+		expect string
+	}{
+		{FormatIntelHex, ":03100000A90160E3\n:00000001FF\n"},
+		{FormatS19, "S0090000676F3635303253\nS1061000A90160DF\nS5030001FB\nS9031000EC\n"},
+		{FormatPRG, "\x00\x10\xa9\x01\x60"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if _, err := WriteImage(&buf, test.format, regions); err != nil {
+			t.Errorf("%v: %v", test.format, err)
+			continue
+		}
+		if buf.String() != test.expect {
+			t.Errorf("%v output incorrect:\n%q\nexpected:\n%q", test.format, buf.String(), test.expect)
+		}
+	}
+
+	if f, err := ParseFormat("srec"); err != nil || f != FormatS19 {
+		t.Errorf("ParseFormat(srec) returned %v, %v", f, err)
+	}
+}
+
+func TestReadImage(t *testing.T) {
+	regions := []Region{
+		{Address: 0x1000, Code: bytes.Repeat([]byte{0xea}, 40)},
+		{Address: 0xfffc, Code: []byte{0x00, 0x10, 0x00, 0x10}},
+	}
+
+	for _, format := range []Format{FormatIntelHex, FormatS19, FormatS28} {
+		var buf bytes.Buffer
+		if _, err := WriteImage(&buf, format, regions); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		if f := DetectFormat(data, ""); f != format && !(format == FormatS28 && f == FormatS19) {
+			t.Errorf("%v: detected format %v", format, f)
+		}
+
+		r, err := ReadImage(data, format)
+		if err != nil {
+			t.Errorf("%v: %v", format, err)
+			continue
+		}
+		if len(r) != 2 || r[0].Address != 0x1000 || !bytes.Equal(r[0].Code, regions[0].Code) ||
+			r[1].Address != 0xfffc || !bytes.Equal(r[1].Code, regions[1].Code) {
+			t.Errorf("%v: regions incorrect: %+v", format, r)
+		}
+	}
+
+	if _, err := ReadImage([]byte(":03100000A90160E4\n"), FormatIntelHex); err == nil {
+		t.Error("expected Intel HEX checksum error")
+	}
+	if _, err := ReadImage([]byte("S1061000A90160DE\n"), FormatS19); err == nil {
+		t.Error("expected S-record checksum error")
+	}
+
+	r, err := ReadImage([]byte{0x01, 0x08, 0x0b, 0x08}, DetectFormat([]byte{0x01, 0x08}, ".PRG"))
+	if err != nil || len(r) != 1 || r[0].Address != 0x0801 || len(r[0].Code) != 2 {
+		t.Errorf("PRG regions incorrect: %+v, %v", r, err)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	asm := `
+	.repeat 3, i
+	.db i*2
+	.endrep
+	.rept 2, i
+	.rept 2, j
+	.db i<<4|j
+	.endr
+	.endr
+	.repeat 2
+	NOP
+	.endrep`
+
+	checkASM(t, asm, "00020400011011EAEA")
+
+	checkASMError(t, "\t.repeat 2\n\tNOP", "parse error")
+	checkASMError(t, "\t.endrep", "parse error")
+	checkASMError(t, "\t.repeat N\n\t.endrep\nN .eq 2", "parse error")
+}
+
+func TestByteFunctions(t *testing.T) {
+	asm := `
+	LDA #.lobyte(TABLE)
+	LDX #.hibyte(TABLE+$100)
+	LDY .LOBYTE($1234),X
+TABLE:
+	.db .hibyte(TABLE)*2, .lobyte(TABLE)`
+
+	checkASM(t, asm, "A906A211B4342006")
+}
+
+func TestEncoding(t *testing.T) {
+	asm := `
+	.db "Ab@"
+	.encoding petscii
+	.db "Ab@", 'a'
+	.encoding screen
+	.db "Ab@"
+	LDA #'a'
+	.encoding apple
+	.ds "Ab"
+	.encoding ascii
+	.charmap 'a', $01, 3
+	.db "abcd"`
+
+	checkASM(t, asm, "416240C1424041410200A901C1E201020364")
+
+	checkASMError(t, "\t.encoding ebcdic", "parse error")
+	checkASMError(t, "\t.charmap 'a'", "parse error")
+}
+
+func TestStructEnumReserve(t *testing.T) {
+	asm := `
+	.struct Point
+x	.word
+y	.word
+	.endstruct
+	.struct Sprite
+pos	.tag Point
+flags	.byte 2
+name	.res 8
+	.endstruct
+	.enum Color
+BLACK
+WHITE
+RED	= 5
+CYAN
+	.endenum
+	.enum
+ZERO
+ONE
+	.ende
+	LDA #Sprite.flags
+	LDX #.sizeof(Sprite)
+	LDY #Point.y
+	.db Color.WHITE, Color.CYAN, ONE
+BUF	.res 3
+	.dsb 2, $FF
+	.db BUF & $FF`
+
+	checkASM(t, asm, "A904A20EA002010601000000FFFF09")
+
+	checkASMError(t, "\t.struct\n\t.endstruct", "parse error")
+	checkASMError(t, "\t.struct S\nx .byte", "parse error")
+	checkASMError(t, "\t.endenum", "parse error")
+	checkASMError(t, "\t.enum E\nA\nA\n\t.endenum", "parse error")
+}
+
+func TestAssertions(t *testing.T) {
+	asm := `
+	.assert END - START == 3, "wrong size"
+START:
+	LDA #1
+	RTS
+END:
+	.assert >START == >END
+	.warning "table not optimized"`
+
+	r := bytes.NewReader([]byte(asm))
+	assembly, _, err := Assemble(r, "test", 0x1000, os.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assembly.Warnings) != 1 || !strings.Contains(assembly.Warnings[0], "line 8, col 18: table not optimized") {
+		t.Errorf("unexpected warnings: %v", assembly.Warnings)
+	}
+
+	r = bytes.NewReader([]byte("\t.org $10FF\n\tNOP\n\tNOP\n\t.assert >$ == $10, \"page crossed\""))
+	assembly, _, err = Assemble(r, "test", 0x1000, os.Stdout, 0)
+	if err == nil || len(assembly.Errors) != 1 || !strings.HasSuffix(assembly.Errors[0], "assertion failed: page crossed") {
+		t.Errorf("expected assertion failure, got %v", assembly.Errors)
+	}
+
+	checkASMError(t, "\t.error \"unsupported\"", "parse error")
+	checkASMError(t, "\t.assert UNDEFINED", "parse error")
+}
+
+func TestZeroPageOptimization(t *testing.T) {
+	asm := `
+	.org $0080
+	LDA VAR
+	STA VAR2,X
+	LDX A:VAR
+	JMP START
+START:
+	RTS
+VAR	.db 0
+VAR2	.db 0`
+
+	checkASM(t, asm, "A58B958CAE8B00"+"4C8A00"+"600000")
+
+	checkASM(t, "\tLDA Z:$12\n\tLDA Z:ZP,X\nZP .eq $34", "A512B534")
+	checkASMError(t, "\tLDA Z:$1234", "parse error")
+
+	r := bytes.NewReader([]byte(asm))
+	var out bytes.Buffer
+	_, _, err := Assemble(r, "test", 0x1000, &out, ReportShortening)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	if !strings.Contains(report, "test line 4: STA $8C,X (shortened to zero page)") ||
+		!strings.Contains(report, "test line 5: LDX $008B (could be shortened") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+}
+
+func TestZeroPageOnlyModes(t *testing.T) {
+	asm := `
+	.ARCH 65c02
+	.ORG $0080
+PTR	.dw 0
+	LDA (PTR),Y
+	LDA (PTR,X)
+	LDA (PTR)
+	STX PTR,Y
+	LDA (Z:PTR),Y
+	LDA (FWD),Y
+FWD	.db 0`
+
+	checkASM(t, asm, "0000"+"B180"+"A180"+"B280"+"9680"+"B180"+"B18E"+"00")
+
+	checkASMError(t, "\t.ORG $1000\nPTR .dw 0\n\tLDA (PTR),Y", "parse error")
+	checkASMError(t, "\t.ORG $1000\nPTR .dw 0\n\tLDA (Z:PTR),Y", "parse error")
+}
+
+func TestBranchRelaxation(t *testing.T) {
+	asm := `
+START:
+	BNE FAR
+	JEQ NEAR
+NEAR:
+	JCC FAR
+	.pad 0, 200
+FAR:
+	RTS`
+
+	checkASMError(t, asm, "parse error")
+
+	r := bytes.NewReader([]byte(strings.Replace(asm, "BNE", "JNE", 1)))
+	assembly, sourceMap, err := Assemble(r, "test", 0x1000, os.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := byteString(assembly.Code[:12])
+	if code != "F0 03 4C D4 10 F0 00 B0 03 4C D4 10" {
+		t.Errorf("unexpected code: %s", code)
+	}
+	if len(sourceMap.Lines) != 6 || sourceMap.Lines[1].Address != 0x1002 || sourceMap.Lines[1].Line != 3 {
+		t.Errorf("unexpected source lines: %+v", sourceMap.Lines)
+	}
+
+	r = bytes.NewReader([]byte(asm))
+	assembly, _, err = Assemble(r, "test", 0x1000, os.Stdout, RelaxBranches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assembly.Code) != 12+200+1 {
+		t.Errorf("unexpected code size %d", len(assembly.Code))
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	asm := `
+	LDA #$20
+	LDQ #$20
+	STA ($20),X
+	.bogus 1`
+
+	r := bytes.NewReader([]byte(asm))
+	assembly, _, err := Assemble(r, "test", 0x1000, os.Stdout, 0)
+	if err == nil {
+		t.Fatal("expected assembly to fail")
+	}
+	if len(assembly.Errors) != 3 || len(assembly.Diagnostics) != 3 {
+		t.Fatalf("expected 3 errors, got %d:\n%s", len(assembly.Errors), strings.Join(assembly.Errors, "\n"))
+	}
+
+	d := assembly.Diagnostics[0]
+	if d.Severity != SeverityError || d.Line != 3 || d.Column != 9 || d.Source != "\tLDQ #$20" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	if d.Context() != "        LDQ #$20\n        ^" {
+		t.Errorf("unexpected context:\n%s", d.Context())
+	}
+	if assembly.Diagnostics[1].Line != 4 || assembly.Diagnostics[2].Line != 5 {
+		t.Errorf("unexpected diagnostics: %+v", assembly.Diagnostics)
+	}
+
+	var out bytes.Buffer
+	if err := assembly.WriteDiagnosticsJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Diagnostic
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 3 || decoded[0] != d {
+		t.Errorf("unexpected JSON diagnostics:\n%s", out.String())
+	}
+}
+
+func TestDiagnosticsCollected(t *testing.T) {
+	asm := `
+	LDQ #$20
+	JMP MISSING
+	BNE FAR
+	.assert FAR < $1000, "too far"
+	.repeat 200
+	NOP
+	.endrep
+FAR	RTS`
+
+	// A parse error doesn't hide the undefined symbol, and neither hides
+	// the out-of-range branch or the failed assertion.
+	r := bytes.NewReader([]byte(asm))
+	assembly, _, err := Assemble(r, "test", 0x1000, os.Stdout, 0)
+	if err == nil {
+		t.Fatal("expected assembly to fail")
+	}
+	want := []string{"invalid opcode", "unresolved expression", "branch offset out of bounds", "too far"}
+	if len(assembly.Diagnostics) != len(want) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(want), len(assembly.Errors), strings.Join(assembly.Errors, "\n"))
+	}
+	for i, d := range assembly.Diagnostics {
+		if d.Line != i+2 || !strings.Contains(d.Message, want[i]) {
+			t.Errorf("diagnostic %d is %+v, expected %q on line %d", i, d, want[i], i+2)
+		}
+	}
+}
+
+func TestDiagnosticsJSONOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.asm")
+	src := "\t.ORG $0080\n\tLDA VAR\n\tLDQ #1\nVAR\t.db 0\n"
+
+	// Failed and successful assemblies with verbose output and a
+	// shortening report write nothing but JSON.
+	for _, s := range []string{src, strings.Replace(src, "LDQ", "LDX", 1)} {
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		AssembleFile(path, JSONDiagnostics|ReportShortening|Verbose, &out)
+		var decoded []Diagnostic
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Errorf("output is not JSON (%v):\n%s", err, out.String())
+		}
+	}
+}
+
+func TestSymbolFiles(t *testing.T) {
+	asm := `
+COUNT = 3
+START:
+	LDX #COUNT
+LOOP:
+	DEX
+	BNE LOOP
+	RTS`
+
+	r := bytes.NewReader([]byte(asm))
+	assembly, sourceMap, err := Assemble(r, "test.asm", 0x1000, os.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	assembly.WriteVICELabels(&out)
+	if out.String() != "al C:0003 .COUNT\nal C:1002 .LOOP\nal C:1000 .START\n" {
+		t.Errorf("unexpected VICE labels:\n%s", out.String())
+	}
+
+	out.Reset()
+	assembly.WriteSymbolList(&out)
+	if out.String() != "COUNT = $03\nLOOP  = $1002\nSTART = $1000\n" {
+		t.Errorf("unexpected symbol list:\n%s", out.String())
+	}
+
+	out.Reset()
+	assembly.WriteDebugInfo(&out, sourceMap, "test.bin")
+	dbg := out.String()
+	for _, s := range []string{
+		"version\tmajor=2,minor=0\n",
+		"info\tcsym=0,file=1,lib=0,line=7,mod=1,scope=1,seg=1,span=4,sym=3,type=0\n",
+		"file\tid=0,name=\"test.asm\",size=0,mtime=0x00000000,mod=0\n",
+		"line\tid=1,file=0,line=6,span=1\n",
+		"mod\tid=0,name=\"test.o\",file=0\n",
+		"seg\tid=0,name=\"CODE\",start=0x001000,size=0x0006,addrsize=absolute,type=rw,oname=\"test.bin\",ooffs=0\n",
+		"span\tid=2,seg=0,start=3,size=2\n",
+		"sym\tid=0,name=\"COUNT\",addrsize=zeropage,size=0,scope=0,def=4,ref=0,val=0x3,type=equ\n",
+		"sym\tid=1,name=\"LOOP\",addrsize=absolute,size=0,scope=0,def=5,ref=2,val=0x1002,seg=0,type=lab\n",
+	} {
+		if !strings.Contains(dbg, s) {
+			t.Errorf("debug info is missing %q:\n%s", s, dbg)
+		}
+	}
+}
+
+func TestReadSymbols(t *testing.T) {
+	asm := `
+COUNT = 3
+START:
+	LDX #COUNT
+	RTS`
+
+	r := bytes.NewReader([]byte(asm))
+	assembly, sourceMap, err := Assemble(r, "test.asm", 0x1000, os.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writers := []func(w *bytes.Buffer) error{
+		func(w *bytes.Buffer) error { return assembly.WriteVICELabels(w) },
+		func(w *bytes.Buffer) error { return assembly.WriteSymbolList(w) },
+		func(w *bytes.Buffer) error { return assembly.WriteDebugInfo(w, sourceMap, "test.bin") },
+	}
+	for i, write := range writers {
+		var b bytes.Buffer
+		write(&b)
+		symbols, err := ReadSymbols(b.Bytes())
+		if err != nil {
+			t.Errorf("file %d: %v", i, err)
+			continue
+		}
+		if len(symbols) != 2 ||
+			symbols[0].Name != "COUNT" || symbols[0].Value != 3 ||
+			symbols[1].Name != "START" || symbols[1].Value != 0x1000 {
+			t.Errorf("file %d: unexpected symbols %+v", i, symbols)
+		}
+	}
+
+	symbols, err := ReadSymbols([]byte("; comment\nCHROUT = $FFD2\nMASK = %1010\nlen=0x10\n"))
+	if err != nil || len(symbols) != 3 || symbols[0].Value != 0xffd2 || symbols[1].Value != 10 || symbols[2].Value != 16 {
+		t.Errorf("unexpected symbols %+v (%v)", symbols, err)
+	}
+
+	if _, err := ReadSymbols([]byte("CHROUT $FFD2\n")); err == nil || err.Error() != "line 1: invalid symbol definition" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+// A charmap translates the characters of string and character literals into
+// the byte values stored by the assembler.
+type charmap [256]byte
+
+// Built-in character encodings, indexed by the names accepted by the
+// .encoding pseudo-op.
+var charmaps = map[string]*charmap{}
+
+func init() {
+	var ascii, petscii, screen, apple charmap
+	for i := 0; i < 256; i++ {
+		c := byte(i)
+		ascii[i] = c
+		petscii[i] = asciiToPetscii(c)
+		screen[i] = petsciiToScreen(petscii[i])
+		apple[i] = c | 0x80
+	}
+
+	for _, n := range []string{"ascii", "none", "raw"} {
+		charmaps[n] = &ascii
+	}
+	for _, n := range []string{"petscii", "pet", "c64"} {
+		charmaps[n] = &petscii
+	}
+	for _, n := range []string{"screen", "scr", "screencode"} {
+		charmaps[n] = &screen
+	}
+	for _, n := range []string{"apple", "appleii", "highbit"} {
+		charmaps[n] = &apple
+	}
+}
+
+// Convert an ASCII character to PETSCII. Letter case is swapped so that
+// lower-case source text appears as upper-case on an unshifted display.
+func asciiToPetscii(c byte) byte {
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return c + 0x80
+	case c >= 'a' && c <= 'z':
+		return c - 0x20
+	case c == '\n':
+		return 0x0d
+	default:
+		return c
+	}
+}
+
+// Convert a PETSCII character to a Commodore 64 screen code.
+func petsciiToScreen(c byte) byte {
+	switch {
+	case c < 0x20:
+		return c + 0x80
+	case c < 0x40:
+		return c
+	case c < 0x60:
+		return c - 0x40
+	case c < 0x80:
+		return c - 0x20
+	case c < 0xa0:
+		return c + 0x40
+	case c < 0xc0:
+		return c - 0x40
+	case c < 0xff:
+		return c - 0x80
+	default:
+		return 0x5e
+	}
+}
+
+// Translate a string using the character map. A nil map leaves the string
+// unchanged.
+func (m *charmap) encode(s string) []byte {
+	b := []byte(s)
+	if m != nil {
+		for i, c := range b {
+			b[i] = m[c]
+		}
+	}
+	return b
+}
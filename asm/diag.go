@@ -0,0 +1,92 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Diagnostic severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// A Diagnostic describes an error or warning reported during assembly.
+type Diagnostic struct {
+	Severity string `json:"severity"` // SeverityError or SeverityWarning
+	File     string `json:"file"`     // Source file name
+	Line     int    `json:"line"`     // 1-based line number
+	Column   int    `json:"column"`   // 1-based column number (tabs expanded)
+	Message  string `json:"message"`  // Description of the problem
+	Source   string `json:"source"`   // Full text of the source line
+}
+
+// Create a diagnostic from an assembler error or warning.
+func (a *assembler) diagnostic(severity string, e asmerror) Diagnostic {
+	return Diagnostic{
+		Severity: severity,
+		File:     a.files[e.line.fileIndex],
+		Line:     e.line.row,
+		Column:   e.line.column + 1,
+		Message:  e.msg,
+		Source:   e.line.full,
+	}
+}
+
+// String returns the diagnostic as a single line of text.
+func (d Diagnostic) String() string {
+	kind := "Syntax error"
+	if d.Severity == SeverityWarning {
+		kind = "Warning"
+	}
+	return fmt.Sprintf("%s in '%s' line %d, col %d: %s", kind, d.File, d.Line, d.Column, d.Message)
+}
+
+// Context returns the diagnostic's source line followed by a line holding
+// a caret beneath the column where the problem was found.
+func (d Diagnostic) Context() string {
+	return expandTabs(d.Source) + "\n" + strings.Repeat(" ", maxInt(0, d.Column-1)) + "^"
+}
+
+// Return a copy of the string with tabs expanded to 8-column tab stops,
+// matching the column numbers assigned by fstring.
+func expandTabs(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\t' {
+			b.WriteString(strings.Repeat(" ", 8-b.Len()%8))
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// WriteDiagnostics writes the assembly's errors and warnings to an output
+// stream as human-readable text, each followed by its source line context.
+func (a *Assembly) WriteDiagnostics(w io.Writer) {
+	for _, d := range a.Diagnostics {
+		fmt.Fprintln(w, d.String())
+		if d.Source != "" {
+			fmt.Fprintln(w, d.Context())
+		}
+	}
+}
+
+// WriteDiagnosticsJSON writes the assembly's errors and warnings to an
+// output stream as a JSON array.
+func (a *Assembly) WriteDiagnosticsJSON(w io.Writer) error {
+	diags := a.Diagnostics
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
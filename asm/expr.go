@@ -7,6 +7,7 @@ package asm
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 //
@@ -38,17 +39,35 @@ const (
 	// opBitwiseXOR
 	// opBitwiseOR
 
-	// value "operations" (16..19)
-	opNumber exprOp = iota + 16
+	// comparison operations (16..21)
+	// opEqual
+	// opNotEqual
+	// opLessEqual
+	// opGreaterEqual
+	// opLess
+	// opGreater
+
+	// value "operations" (22..25)
+	opNumber exprOp = iota + 22
 	opString
 	opIdentifier
 	opHere
 
-	// pseudo-ops (20..21) (used only during parsing but not stored in expr's)
+	// pseudo-ops (26..27) (used only during parsing but not stored in expr's)
 	opLeftParen
 	opRightParen
+
+	// function operations (28..29)
+	opLoByte
+	opHiByte
 )
 
+// Functions that may be called from expressions
+var exprFuncs = map[string]exprOp{
+	".lobyte": opLoByte,
+	".hibyte": opHiByte,
+}
+
 type opdata struct {
 	precedence      byte
 	children        int
@@ -68,24 +87,32 @@ func (o *opdata) isUnary() bool {
 // One entry per exprOp value (order must match)
 var ops = []opdata{
 	// unary operations
-	{7, 1, false, "-", func(a, b int) int { return -a }},              // uminus
-	{7, 1, false, "+", func(a, b int) int { return a }},               // uplus
-	{7, 1, false, "<", func(a, b int) int { return a & 0xff }},        // ulessthan
-	{7, 1, false, ">", func(a, b int) int { return (a >> 8) & 0xff }}, // ugreaterthan
-	{7, 1, false, "/", func(a, b int) int { return (a >> 8) & 0xff }}, // uslash
-	{7, 1, false, "~", func(a, b int) int { return 0xffffffff ^ a }},  // bitneg
+	{8, 1, false, "-", func(a, b int) int { return -a }},              // uminus
+	{8, 1, false, "+", func(a, b int) int { return a }},               // uplus
+	{8, 1, false, "<", func(a, b int) int { return a & 0xff }},        // ulessthan
+	{8, 1, false, ">", func(a, b int) int { return (a >> 8) & 0xff }}, // ugreaterthan
+	{8, 1, false, "/", func(a, b int) int { return (a >> 8) & 0xff }}, // uslash
+	{8, 1, false, "~", func(a, b int) int { return 0xffffffff ^ a }},  // bitneg
 
 	// binary operations
-	{6, 2, true, "*", func(a, b int) int { return a * b }},           // multiply
-	{6, 2, true, "/", func(a, b int) int { return a / b }},           // divide
-	{6, 2, true, "%", func(a, b int) int { return a % b }},           // modulo
-	{5, 2, true, "+", func(a, b int) int { return a + b }},           // add
-	{5, 2, true, "-", func(a, b int) int { return a - b }},           // subtract
-	{4, 2, true, "<<", func(a, b int) int { return a << uint32(b) }}, // shift_left
-	{4, 2, true, ">>", func(a, b int) int { return a >> uint32(b) }}, // shift_right
-	{3, 2, true, "&", func(a, b int) int { return a & b }},           // and
-	{2, 2, true, "^", func(a, b int) int { return a ^ b }},           // xor
-	{1, 2, true, "|", func(a, b int) int { return a | b }},           // or
+	{7, 2, true, "*", func(a, b int) int { return a * b }},           // multiply
+	{7, 2, true, "/", func(a, b int) int { return a / b }},           // divide
+	{7, 2, true, "%", func(a, b int) int { return a % b }},           // modulo
+	{6, 2, true, "+", func(a, b int) int { return a + b }},           // add
+	{6, 2, true, "-", func(a, b int) int { return a - b }},           // subtract
+	{5, 2, true, "<<", func(a, b int) int { return a << uint32(b) }}, // shift_left
+	{5, 2, true, ">>", func(a, b int) int { return a >> uint32(b) }}, // shift_right
+	{4, 2, true, "&", func(a, b int) int { return a & b }},           // and
+	{3, 2, true, "^", func(a, b int) int { return a ^ b }},           // xor
+	{2, 2, true, "|", func(a, b int) int { return a | b }},           // or
+
+	// comparison operations (two-character symbols must precede "<" and ">")
+	{1, 2, true, "==", func(a, b int) int { return boolToInt(a == b) }}, // equal
+	{1, 2, true, "!=", func(a, b int) int { return boolToInt(a != b) }}, // not_equal
+	{1, 2, true, "<=", func(a, b int) int { return boolToInt(a <= b) }}, // less_equal
+	{1, 2, true, ">=", func(a, b int) int { return boolToInt(a >= b) }}, // greater_equal
+	{1, 2, true, "<", func(a, b int) int { return boolToInt(a < b) }},   // less
+	{1, 2, true, ">", func(a, b int) int { return boolToInt(a > b) }},   // greater
 
 	// value "operations"
 	{0, 0, false, "", nil}, // numeric literal
@@ -96,6 +123,10 @@ var ops = []opdata{
 	// pseudo-operations
 	{0, 0, false, "", nil}, // lparen
 	{0, 0, false, "", nil}, // rparen
+
+	// function operations
+	{8, 1, false, ".lobyte", func(a, b int) int { return a & 0xff }},        // lobyte
+	{8, 1, false, ".hibyte", func(a, b int) int { return (a >> 8) & 0xff }}, // hibyte
 }
 
 func (op exprOp) isBinary() bool {
@@ -175,6 +206,38 @@ func (e *expr) String() string {
 	}
 }
 
+// Return the symbol table key of an identifier expression. Local labels
+// are qualified by the scope label active when the expression was parsed.
+func (e *expr) symbol() string {
+	if e.identifier.startsWithChar('.') || e.identifier.startsWithChar('@') {
+		return "~" + e.scopeLabel.str + e.identifier.str
+	}
+	return e.identifier.str
+}
+
+// Call fn for every identifier node in the expression tree.
+func (e *expr) walkIdentifiers(fn func(e *expr)) {
+	if e == nil {
+		return
+	}
+	if e.op == opIdentifier {
+		fn(e)
+	}
+	e.child0.walkIdentifiers(fn)
+	e.child1.walkIdentifiers(fn)
+}
+
+// Clear the evaluated state of the expression tree so that it may be
+// evaluated again after label addresses change.
+func (e *expr) reset() {
+	if e == nil || e.op == opNumber || e.op == opString {
+		return
+	}
+	e.evaluated = false
+	e.child0.reset()
+	e.child1.reset()
+}
+
 // Evaluate the expression tree.
 func (e *expr) eval(addr int, constants map[string]*expr, labels map[string]int) bool {
 	if !e.evaluated {
@@ -186,13 +249,7 @@ func (e *expr) eval(addr int, constants map[string]*expr, labels map[string]int)
 			e.evaluated = true
 
 		case e.op == opIdentifier:
-			var ident string
-			switch {
-			case e.identifier.startsWithChar('.') || e.identifier.startsWithChar('@'):
-				ident = "~" + e.scopeLabel.str + e.identifier.str
-			default:
-				ident = e.identifier.str
-			}
+			ident := e.symbol()
 			if m, ok := constants[ident]; ok {
 				e.bytes = maxInt(e.bytes, m.bytes)
 				if m.address {
@@ -253,6 +310,7 @@ const (
 	tokenHere
 	tokenLeftParen
 	tokenRightParen
+	tokenFunction
 )
 
 func (tt tokentype) isValue() bool {
@@ -260,7 +318,7 @@ func (tt tokentype) isValue() bool {
 }
 
 func (tt tokentype) canPrecedeUnaryOp() bool {
-	return tt == tokenOp || tt == tokenLeftParen || tt == tokenNil
+	return tt == tokenOp || tt == tokenLeftParen || tt == tokenFunction || tt == tokenNil
 }
 
 type token struct {
@@ -282,6 +340,8 @@ type exprParser struct {
 	parenCounter  int
 	flags         parseFlags
 	prevTokenType tokentype
+	vars          map[string]int // values of active .repeat loop variables
+	charmap       *charmap       // encoding applied to character literals
 	errors        []asmerror
 }
 
@@ -358,6 +418,10 @@ func (p *exprParser) parse(line, scopeLabel fstring, flags parseFlags) (e *expr,
 		case tokenLeftParen:
 			p.operatorStack.push(opLeftParen)
 
+		case tokenFunction:
+			p.operatorStack.push(token.op)
+			p.operatorStack.push(opLeftParen)
+
 		case tokenRightParen:
 			for err == nil {
 				if p.operatorStack.empty() {
@@ -469,7 +533,7 @@ func (p *exprParser) parseToken(line fstring) (t token, remain fstring, err erro
 		t.typ, t.op = tokenLeftParen, opLeftParen
 		remain = line.consume(1)
 
-	case line.startsWithChar(')') && (p.flags&allowParentheses) != 0:
+	case line.startsWithChar(')') && ((p.flags&allowParentheses) != 0 || p.parenCounter > 0):
 		if p.parenCounter == 0 {
 			p.addError(line, "mismatched parentheses")
 			err = errParse
@@ -487,6 +551,35 @@ func (p *exprParser) parseToken(line fstring) (t token, remain fstring, err erro
 			err = errParse
 		}
 
+		// A function name followed by a parenthesis starts a function call.
+		// The function's parentheses are permitted even where grouping
+		// parentheses are not.
+		if op, ok := exprFuncs[strings.ToLower(t.identifier.str)]; ok && remain.startsWithChar('(') {
+			p.parenCounter++
+			t.typ, t.op, remain = tokenFunction, op, remain.consume(1)
+			break
+		}
+
+		// The size of a struct is stored in the constant "name.sizeof".
+		if strings.ToLower(t.identifier.str) == ".sizeof" && remain.startsWithChar('(') {
+			rest := remain.consume(1).consumeWhitespace()
+			arg, rest := rest.consumeWhile(identifierChar)
+			rest = rest.consumeWhitespace()
+			if arg.isEmpty() || !rest.startsWithChar(')') {
+				p.addError(remain, "invalid .sizeof expression")
+				err = errParse
+				break
+			}
+			t.identifier, remain = arg, rest.consume(1)
+			t.identifier.str += ".sizeof"
+			break
+		}
+
+		// Loop variables are replaced by their current values.
+		if v, ok := p.vars[t.identifier.str]; ok {
+			t.typ, t.value, t.bytes = tokenNumber, v, valueBytes(v)
+		}
+
 	default:
 		for i, o := range ops {
 			if o.children > 0 && line.startsWithString(o.symbol) {
@@ -616,10 +709,33 @@ func (p *exprParser) parseCharLiteral(line fstring) (value int, remain fstring,
 	}
 
 	value = int(line.str[1])
+	if p.charmap != nil {
+		value = int(p.charmap[line.str[1]])
+	}
 	remain = line.consume(3)
 	return value, remain, nil
 }
 
+// Return 1 if the condition is true, or 0 otherwise.
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Return the minimum number of bytes required to hold a value.
+func valueBytes(v int) int {
+	switch {
+	case v >= 0 && v <= 0xff:
+		return 1
+	case v >= 0 && v <= 0xffff:
+		return 2
+	default:
+		return 4
+	}
+}
+
 func (p *exprParser) addError(line fstring, msg string) {
 	p.errors = append(p.errors, asmerror{line, msg})
 }
@@ -0,0 +1,428 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Format identifies the file format used to store machine code.
+type Format byte
+
+// Machine code file formats.
+const (
+	FormatBinary   Format = iota // raw binary data
+	FormatIntelHex               // Intel HEX records
+	FormatS19                    // Motorola S-records with 16-bit addresses
+	FormatS28                    // Motorola S-records with 24-bit addresses
+	FormatPRG                    // Commodore program file with load address
+)
+
+// One entry per Format value (order must match)
+var formats = []struct {
+	name    string
+	ext     string
+	aliases []string
+}{
+	{"bin", ".bin", []string{"binary", "raw"}},
+	{"hex", ".hex", []string{"ihex", "intelhex", "ihx"}},
+	{"s19", ".s19", []string{"srec", "s1", "mot"}},
+	{"s28", ".s28", []string{"s2"}},
+	{"prg", ".prg", []string{}},
+}
+
+var (
+	errFormatRegions = errors.New("PRG format requires a single contiguous region")
+	errImageTooLarge = errors.New("image data exceeds 64K address space")
+)
+
+// ParseFormat returns the file format matching a format name such as "hex"
+// or "s19".
+func ParseFormat(name string) (Format, error) {
+	name = strings.ToLower(name)
+	for i, f := range formats {
+		if name == f.name {
+			return Format(i), nil
+		}
+		for _, a := range f.aliases {
+			if name == a {
+				return Format(i), nil
+			}
+		}
+	}
+	return FormatBinary, fmt.Errorf("unknown file format '%s'", name)
+}
+
+// String returns the name of the file format.
+func (f Format) String() string {
+	return formats[f].name
+}
+
+// Ext returns the file extension conventionally used by the file format.
+func (f Format) Ext() string {
+	return formats[f].ext
+}
+
+// A Region is a contiguous block of machine code stored at a memory
+// address.
+type Region struct {
+	Address uint16 // Address of the first byte of code
+	Code    []byte // Machine code
+}
+
+// WriteImage writes one or more regions of machine code to an output stream
+// using the requested file format.
+func WriteImage(w io.Writer, f Format, regions []Region) (n int64, err error) {
+	ww := bufio.NewWriter(w)
+	cw := &countWriter{w: ww}
+
+	switch f {
+	case FormatBinary:
+		for _, r := range regions {
+			cw.Write(r.Code)
+		}
+	case FormatIntelHex:
+		writeIntelHex(cw, regions)
+	case FormatS19:
+		writeSRecords(cw, regions, 2)
+	case FormatS28:
+		writeSRecords(cw, regions, 3)
+	case FormatPRG:
+		if len(regions) != 1 {
+			return 0, errFormatRegions
+		}
+		cw.Write(toBytes(2, int(regions[0].Address)))
+		cw.Write(regions[0].Code)
+	default:
+		return 0, fmt.Errorf("unsupported file format %d", f)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, ww.Flush()
+}
+
+// Maximum number of data bytes stored in a single hex record.
+const recordBytes = 16
+
+// Write regions as Intel HEX data records followed by an end-of-file
+// record. Extended linear address records are emitted whenever the data
+// crosses a 64K boundary.
+func writeIntelHex(w io.Writer, regions []Region) {
+	upper := 0
+	for _, r := range regions {
+		for i, n := 0, 0; i < len(r.Code); i += n {
+			addr := int(r.Address) + i
+			if addr>>16 != upper {
+				upper = addr >> 16
+				writeHexRecord(w, 0x04, 0, []byte{byte(upper >> 8), byte(upper)})
+			}
+			n = minInt(recordBytes, len(r.Code)-i)
+			n = minInt(n, 0x10000-(addr&0xffff))
+			writeHexRecord(w, 0x00, uint16(addr), r.Code[i:i+n])
+		}
+	}
+	writeHexRecord(w, 0x01, 0, nil)
+}
+
+// Write a single Intel HEX record.
+func writeHexRecord(w io.Writer, typ byte, addr uint16, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+	for _, b := range data {
+		sum += b
+	}
+	fmt.Fprintf(w, ":%02X%04X%02X%s%02X\n", len(data), addr, typ, hexString(data), -sum)
+}
+
+// Write regions as Motorola S-records using 2-byte (S19) or 3-byte (S28)
+// addresses. The output begins with a header record and ends with a record
+// count and a termination record holding the first region's address.
+func writeSRecords(w io.Writer, regions []Region, addrBytes int) {
+	dataType, termType := byte('1'), byte('9')
+	if addrBytes == 3 {
+		dataType, termType = '2', '8'
+	}
+
+	writeSRecord(w, '0', 2, 0, []byte("go6502"))
+
+	count := 0
+	for _, r := range regions {
+		for i, n := 0, 0; i < len(r.Code); i += n {
+			n = minInt(recordBytes, len(r.Code)-i)
+			addr := int(r.Address) + i
+			if addrBytes == 2 {
+				addr &= 0xffff
+			}
+			writeSRecord(w, dataType, addrBytes, addr, r.Code[i:i+n])
+			count++
+		}
+	}
+
+	writeSRecord(w, '5', 2, count&0xffff, nil)
+
+	start := 0
+	if len(regions) > 0 {
+		start = int(regions[0].Address)
+	}
+	writeSRecord(w, termType, addrBytes, start, nil)
+}
+
+// Write a single Motorola S-record.
+func writeSRecord(w io.Writer, typ byte, addrBytes int, addr int, data []byte) {
+	rec := make([]byte, 0, 1+addrBytes+len(data))
+	rec = append(rec, byte(addrBytes+len(data)+1))
+	for i := addrBytes - 1; i >= 0; i-- {
+		rec = append(rec, byte(addr>>(8*i)))
+	}
+	rec = append(rec, data...)
+
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	fmt.Fprintf(w, "S%c%s%02X\n", typ, hexString(rec), ^sum)
+}
+
+// Return an unseparated hexadecimal string representation of a byte slice.
+func hexString(b []byte) string {
+	s := make([]byte, len(b)*2)
+	for i, v := range b {
+		s[i*2+0] = hex[v>>4]
+		s[i*2+1] = hex[v&0x0f]
+	}
+	return string(s)
+}
+
+// DetectFormat examines the contents of a machine code file and its file
+// extension to determine the file's format. Intel HEX and S-record files are
+// recognized by their contents. Other files are assumed to contain raw
+// binary data unless the extension identifies them as PRG files.
+func DetectFormat(data []byte, ext string) Format {
+	text := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case len(text) > 0 && text[0] == ':' && isText(text):
+		return FormatIntelHex
+	case len(text) > 1 && text[0] == 'S' && text[1] >= '0' && text[1] <= '9' && isText(text):
+		return FormatS19 // ReadImage accepts all S-record address sizes
+	case strings.ToLower(ext) == ".prg":
+		return FormatPRG
+	default:
+		return FormatBinary
+	}
+}
+
+// Return true if the data contains only printable ASCII and whitespace.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadImage parses machine code stored in the requested file format and
+// returns the regions of memory it describes. Adjacent data records are
+// merged into a single region. Record checksums are validated, and an error
+// is returned if any of them fail.
+func ReadImage(data []byte, f Format) ([]Region, error) {
+	switch f {
+	case FormatBinary:
+		if len(data) > 0x10000 {
+			return nil, errImageTooLarge
+		}
+		return []Region{{Address: 0, Code: data}}, nil
+	case FormatPRG:
+		if len(data) < 2 {
+			return nil, errors.New("PRG file is missing its load address")
+		}
+		addr := int(data[0]) | int(data[1])<<8
+		if addr+len(data)-2 > 0x10000 {
+			return nil, errImageTooLarge
+		}
+		return []Region{{Address: uint16(addr), Code: data[2:]}}, nil
+	case FormatIntelHex:
+		return readIntelHex(data)
+	case FormatS19, FormatS28:
+		return readSRecords(data)
+	default:
+		return nil, fmt.Errorf("unsupported file format %d", f)
+	}
+}
+
+// An imageBuilder accumulates data records into regions.
+type imageBuilder struct {
+	regions []Region
+}
+
+// Add a block of data at the given address, extending the most recent
+// region if the data immediately follows it.
+func (b *imageBuilder) add(addr int, data []byte) error {
+	if addr+len(data) > 0x10000 {
+		return errImageTooLarge
+	}
+	if n := len(b.regions); n > 0 {
+		r := &b.regions[n-1]
+		if int(r.Address)+len(r.Code) == addr {
+			r.Code = append(r.Code, data...)
+			return nil
+		}
+	}
+	b.regions = append(b.regions, Region{
+		Address: uint16(addr),
+		Code:    append([]byte{}, data...),
+	})
+	return nil
+}
+
+// Decode a line of hexadecimal record text.
+func decodeRecord(line string, row int) ([]byte, error) {
+	if len(line) < 2 || len(line)%2 != 0 {
+		return nil, fmt.Errorf("line %d: invalid record", row)
+	}
+	rec := make([]byte, len(line)/2)
+	for i := range rec {
+		hi, lo := hexDigit(line[i*2]), hexDigit(line[i*2+1])
+		if hi < 0 || lo < 0 {
+			return nil, fmt.Errorf("line %d: invalid record", row)
+		}
+		rec[i] = byte(hi<<4 | lo)
+	}
+	return rec, nil
+}
+
+// Return the value of a hexadecimal digit, or -1 if it isn't one.
+func hexDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	default:
+		return -1
+	}
+}
+
+// Parse Intel HEX records.
+func readIntelHex(data []byte) ([]Region, error) {
+	var b imageBuilder
+	base := 0
+	for row, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return nil, fmt.Errorf("line %d: missing record mark", row+1)
+		}
+
+		rec, err := decodeRecord(line[1:], row+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return nil, fmt.Errorf("line %d: invalid record length", row+1)
+		}
+
+		var sum byte
+		for _, v := range rec {
+			sum += v
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", row+1)
+		}
+
+		addr := int(rec[1])<<8 | int(rec[2])
+		payload := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00:
+			if err := b.add(base+addr, payload); err != nil {
+				return nil, fmt.Errorf("line %d: %v", row+1, err)
+			}
+		case 0x01:
+			return b.regions, nil
+		case 0x02, 0x04:
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("line %d: invalid address record", row+1)
+			}
+			base = int(payload[0])<<8 | int(payload[1])
+			if rec[3] == 0x02 {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case 0x03, 0x05:
+			// Start address records are ignored.
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %02X", row+1, rec[3])
+		}
+	}
+	return b.regions, nil
+}
+
+// Parse Motorola S-records.
+func readSRecords(data []byte) ([]Region, error) {
+	var b imageBuilder
+	for row, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] != 'S' {
+			return nil, fmt.Errorf("line %d: missing record mark", row+1)
+		}
+
+		rec, err := decodeRecord(line[2:], row+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) != int(rec[0])+1 {
+			return nil, fmt.Errorf("line %d: invalid record length", row+1)
+		}
+
+		var sum byte
+		for _, v := range rec {
+			sum += v
+		}
+		if sum != 0xff {
+			return nil, fmt.Errorf("line %d: checksum mismatch", row+1)
+		}
+
+		var addrBytes int
+		switch line[1] {
+		case '1':
+			addrBytes = 2
+		case '2':
+			addrBytes = 3
+		case '3':
+			addrBytes = 4
+		case '0', '5', '6', '7', '8', '9':
+			// Header, count and termination records carry no data.
+			continue
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type S%c", row+1, line[1])
+		}
+
+		if len(rec) < addrBytes+2 {
+			return nil, fmt.Errorf("line %d: invalid record length", row+1)
+		}
+		addr := 0
+		for _, v := range rec[1 : 1+addrBytes] {
+			addr = addr<<8 | int(v)
+		}
+		if err := b.add(addr, rec[1+addrBytes:len(rec)-1]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", row+1, err)
+		}
+	}
+	return b.regions, nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A Listing describes the machine code produced by an assembly alongside
+// the source code that generated it.
+type Listing struct {
+	Files   []string      // Source code files
+	Lines   []ListingLine // Source code lines, in the order they were read
+	Symbols []Symbol      // Labels and constants, sorted by name
+}
+
+// A ListingLine represents a single line of source code and the machine
+// code generated from it.
+type ListingLine struct {
+	FileIndex int    // Source code file index
+	Line      int    // Source code line number
+	Depth     int    // Include nesting depth of the source file
+	Address   int    // Machine code address (-1 if the line has none)
+	Code      []byte // Machine code generated by the line
+	Cycles    int    // CPU cycles consumed by the line's instruction
+	BPCycles  int    // Additional cycles if a page boundary is crossed
+	Equate    bool   // True if the line declares a constant
+	Value     int    // Value of the constant declared on the line
+	Source    string // Source code text
+}
+
+// A Symbol describes a label or constant defined by the assembly code.
+type Symbol struct {
+	Name      string      // Symbol name
+	Value     int         // Resolved value
+	Label     bool        // True if the symbol is an address label
+	FileIndex int         // Index of file containing the definition
+	Line      int         // Line number of the definition
+	Refs      []SymbolRef // Source lines referencing the symbol
+}
+
+// A SymbolRef identifies a source code line that references a symbol.
+type SymbolRef struct {
+	FileIndex int // Source code file index
+	Line      int // Source code line number
+}
+
+// Build a listing from the state of a completed assembly.
+func (a *assembler) buildListing() *Listing {
+	l := &Listing{
+		Files:   a.files,
+		Lines:   make([]ListingLine, 0, len(a.srclines)),
+		Symbols: a.symbols(),
+	}
+
+	for _, sl := range a.srclines {
+		ll := ListingLine{
+			FileIndex: sl.line.fileIndex,
+			Line:      sl.line.row,
+			Depth:     sl.depth,
+			Address:   -1,
+			Source:    sl.line.full,
+		}
+
+		stripped := sl.line.stripTrailingComment()
+		isLabel := !stripped.isEmpty() && stripped.startsWith(labelStartChar)
+
+		if sl.seg1 > sl.seg0 {
+			ll.Code = a.code[a.segcode[sl.seg0]:a.segcode[sl.seg1]]
+		}
+		if len(ll.Code) > 0 || (isLabel && sl.value == nil) {
+			ll.Address = a.segaddr(sl.seg0)
+		}
+		for _, s := range a.segments[sl.seg0:sl.seg1] {
+			if i, ok := s.(*instruction); ok {
+				ll.Cycles, ll.BPCycles = int(i.inst.Cycles), int(i.inst.BPCycles)
+			}
+		}
+		if sl.value != nil {
+			ll.Equate, ll.Value = true, sl.value.value
+		}
+
+		l.Lines = append(l.Lines, ll)
+	}
+
+	return l
+}
+
+// Return all labels and constants defined by the assembly, along with
+// the source lines that reference them.
+func (a *assembler) symbols() []Symbol {
+	refs := make(map[string][]SymbolRef)
+	addRefs := func(e *expr) {
+		e.walkIdentifiers(func(e *expr) {
+			sym := e.symbol()
+			r := SymbolRef{FileIndex: e.identifier.fileIndex, Line: e.identifier.row}
+			if rr := refs[sym]; len(rr) == 0 || rr[len(rr)-1] != r {
+				refs[sym] = append(rr, r)
+			}
+		})
+	}
+
+	for _, sl := range a.srclines {
+		addRefs(sl.value)
+		for _, s := range a.segments[sl.seg0:sl.seg1] {
+			for _, e := range segmentExprs(s) {
+				addRefs(e)
+			}
+		}
+	}
+
+	symbols := make([]Symbol, 0, len(a.constants))
+	for name, e := range a.constants {
+		_, label := a.labels[name]
+		def := a.defs[name]
+		symbols = append(symbols, Symbol{
+			Name:      strings.TrimPrefix(name, "~"),
+			Value:     e.value,
+			Label:     label,
+			FileIndex: def.fileIndex,
+			Line:      def.row,
+			Refs:      refs[name],
+		})
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
+// Return all expressions contained within a segment.
+func segmentExprs(s segment) []*expr {
+	switch ss := s.(type) {
+	case *instruction:
+		return []*expr{ss.operand.expr}
+	case *data:
+		return ss.exprs
+	case *padding:
+		return []*expr{ss.valExpr, ss.lenExpr}
+	case *export:
+		return []*expr{ss.expr}
+	case *assertion:
+		return []*expr{ss.expr}
+	default:
+		return nil
+	}
+}
+
+// WriteTo writes the listing in human-readable text form to an output
+// stream.
+func (l *Listing) WriteTo(w io.Writer) (n int64, err error) {
+	ww := bufio.NewWriter(w)
+	cw := &countWriter{w: ww}
+
+	if len(l.Files) > 0 {
+		fmt.Fprintf(cw, "Assembly listing for '%s'\n\n", l.Files[0])
+	}
+	fmt.Fprintf(cw, "  Line  Addr  Code      Cyc  Source\n")
+
+	fileIndex := 0
+	for _, ll := range l.Lines {
+		if ll.FileIndex != fileIndex {
+			fileIndex = ll.FileIndex
+			fmt.Fprintf(cw, "%29s; ---- %s ----\n", "", l.file(fileIndex))
+		}
+
+		depth := " "
+		if ll.Depth > 0 {
+			depth = fmt.Sprintf("%d", ll.Depth)
+		}
+
+		var addr string
+		if ll.Address != -1 {
+			addr = fmt.Sprintf("%04X", ll.Address)
+		}
+
+		code := byteString(ll.Code[:minInt(3, len(ll.Code))])
+		if ll.Equate {
+			code = fmt.Sprintf("=$%04X", ll.Value)
+		}
+
+		var cycles string
+		if ll.Cycles > 0 {
+			cycles = fmt.Sprintf("%d", ll.Cycles)
+			if ll.BPCycles > 0 {
+				cycles += "+"
+			}
+		}
+
+		fmt.Fprintf(cw, "%s%5d  %-4s  %-8s  %-3s  %s\n", depth, ll.Line, addr, code, cycles, ll.Source)
+
+		// Continue long runs of data on subsequent lines.
+		for i := 3; i < len(ll.Code); i += 3 {
+			j := minInt(i+3, len(ll.Code))
+			fmt.Fprintf(cw, "%6s  %04X  %-8s\n", "", ll.Address+i, byteString(ll.Code[i:j]))
+		}
+	}
+
+	if len(l.Symbols) > 0 {
+		fmt.Fprintf(cw, "\nSymbol table:\n")
+		for _, s := range l.Symbols {
+			kind := "const"
+			if s.Label {
+				kind = "label"
+			}
+			fmt.Fprintf(cw, "    %-16s $%04X  %-5s  %s:%d\n", s.Name, uint16(s.Value), kind, l.file(s.FileIndex), s.Line)
+		}
+
+		fmt.Fprintf(cw, "\nCross-reference:\n")
+		for _, s := range l.Symbols {
+			refs := make([]string, len(s.Refs))
+			for i, r := range s.Refs {
+				refs[i] = fmt.Sprintf("%s:%d", l.file(r.FileIndex), r.Line)
+			}
+			if len(refs) == 0 {
+				refs = []string{"(unreferenced)"}
+			}
+			fmt.Fprintf(cw, "    %-16s %s\n", s.Name, strings.Join(refs, " "))
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, ww.Flush()
+}
+
+// Return the name of a listing source file.
+func (l *Listing) file(fileIndex int) string {
+	if fileIndex < len(l.Files) {
+		return l.Files[fileIndex]
+	}
+	return "?"
+}
+
+// A countWriter counts the bytes written to an output stream and
+// remembers the first error encountered.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,324 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteVICELabels writes the assembly's labels and constants as a VICE
+// monitor label file, which may be loaded with the monitor's "ll" command.
+func (a *Assembly) WriteVICELabels(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range a.Symbols {
+		fmt.Fprintf(bw, "al C:%04X .%s\n", s.Value&0xffff, s.Name)
+	}
+	return bw.Flush()
+}
+
+// WriteSymbolList writes the assembly's labels and constants as a plain
+// text list of "name = $value" assignments, sorted by name.
+func (a *Assembly) WriteSymbolList(w io.Writer) error {
+	width := 0
+	for _, s := range a.Symbols {
+		width = maxInt(width, len(s.Name))
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, s := range a.Symbols {
+		fmt.Fprintf(bw, "%-*s = %s\n", width, s.Name, symbolValue(s.Value))
+	}
+	return bw.Flush()
+}
+
+// Format a symbol value as a hexadecimal number sized to fit it.
+func symbolValue(v int) string {
+	switch {
+	case v < 0:
+		return strconv.Itoa(v)
+	case v < 0x100:
+		return fmt.Sprintf("$%02X", v)
+	case v < 0x10000:
+		return fmt.Sprintf("$%04X", v)
+	default:
+		return fmt.Sprintf("$%08X", v)
+	}
+}
+
+// A dbgLine identifies a source line in an ld65 debug info file.
+type dbgLine struct {
+	fileIndex int
+	line      int
+}
+
+// WriteDebugInfo writes an ld65-compatible debug info file describing the
+// assembly. The source map supplies the source file names and the address
+// of each assembled line, and output names the machine code file.
+func (a *Assembly) WriteDebugInfo(w io.Writer, m *SourceMap, output string) error {
+	// Sort the assembled lines by address and give each line a span
+	// covering the machine code it generated.
+	lines := make([]SourceLine, len(m.Lines))
+	copy(lines, m.Lines)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Address < lines[j].Address
+	})
+
+	end := int(a.Origin) + len(a.Code)
+	type span struct{ start, size int }
+	spans := make([]span, 0, len(lines))
+	spanOf := make(map[dbgLine]int)
+	for i, l := range lines {
+		next := end
+		if i+1 < len(lines) {
+			next = lines[i+1].Address
+		}
+		if next <= l.Address {
+			continue
+		}
+		key := dbgLine{l.FileIndex, l.Line}
+		if _, ok := spanOf[key]; !ok {
+			spanOf[key] = len(spans)
+			spans = append(spans, span{l.Address - int(a.Origin), next - l.Address})
+		}
+	}
+
+	// Number every source line that generated code, defined a symbol or
+	// referenced one.
+	var ids []dbgLine
+	lineID := make(map[dbgLine]int)
+	addLine := func(l dbgLine) int {
+		id, ok := lineID[l]
+		if !ok {
+			id = len(ids)
+			lineID[l] = id
+			ids = append(ids, l)
+		}
+		return id
+	}
+	for _, l := range lines {
+		if _, ok := spanOf[dbgLine{l.FileIndex, l.Line}]; ok {
+			addLine(dbgLine{l.FileIndex, l.Line})
+		}
+	}
+	for _, s := range a.Symbols {
+		addLine(dbgLine{s.FileIndex, s.Line})
+		for _, r := range s.Refs {
+			addLine(dbgLine{r.FileIndex, r.Line})
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version\tmajor=2,minor=0\n")
+	fmt.Fprintf(bw, "info\tcsym=0,file=%d,lib=0,line=%d,mod=1,scope=1,seg=1,span=%d,sym=%d,type=0\n",
+		len(m.Files), len(ids), len(spans), len(a.Symbols))
+
+	for i, f := range m.Files {
+		fmt.Fprintf(bw, "file\tid=%d,name=%q,size=0,mtime=0x00000000,mod=0\n", i, f)
+	}
+
+	for id, l := range ids {
+		fmt.Fprintf(bw, "line\tid=%d,file=%d,line=%d", id, l.fileIndex, l.line)
+		if s, ok := spanOf[l]; ok {
+			fmt.Fprintf(bw, ",span=%d", s)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	module := "main"
+	if len(m.Files) > 0 {
+		module = m.Files[0]
+	}
+	module = strings.TrimSuffix(filepath.Base(module), filepath.Ext(module)) + ".o"
+	fmt.Fprintf(bw, "mod\tid=0,name=%q,file=0\n", module)
+
+	fmt.Fprintf(bw, "scope\tid=0,name=\"\",mod=0,size=%d", len(a.Code))
+	if len(spans) > 0 {
+		ss := make([]string, len(spans))
+		for i := range spans {
+			ss[i] = strconv.Itoa(i)
+		}
+		fmt.Fprintf(bw, ",span=%s", strings.Join(ss, "+"))
+	}
+	fmt.Fprintln(bw)
+
+	fmt.Fprintf(bw, "seg\tid=0,name=\"CODE\",start=0x%06X,size=0x%04X,addrsize=absolute,type=rw,oname=%q,ooffs=0\n",
+		a.Origin, len(a.Code), filepath.Base(output))
+
+	for i, s := range spans {
+		fmt.Fprintf(bw, "span\tid=%d,seg=0,start=%d,size=%d\n", i, s.start, s.size)
+	}
+
+	for i, s := range a.Symbols {
+		addrsize := "absolute"
+		if s.Value >= 0 && s.Value < 0x100 {
+			addrsize = "zeropage"
+		}
+		fmt.Fprintf(bw, "sym\tid=%d,name=%q,addrsize=%s,size=0,scope=0,def=%d",
+			i, s.Name, addrsize, lineID[dbgLine{s.FileIndex, s.Line}])
+		if len(s.Refs) > 0 {
+			refs := make([]string, 0, len(s.Refs))
+			for _, r := range s.Refs {
+				refs = append(refs, strconv.Itoa(lineID[dbgLine{r.FileIndex, r.Line}]))
+			}
+			fmt.Fprintf(bw, ",ref=%s", strings.Join(refs, "+"))
+		}
+		if s.Label {
+			fmt.Fprintf(bw, ",val=0x%X,seg=0,type=lab\n", s.Value)
+		} else {
+			fmt.Fprintf(bw, ",val=0x%X,type=equ\n", s.Value)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadSymbols parses a symbol file produced by another tool and returns the
+// symbols it defines. VICE label files, ld65 debug info files and plain
+// "name = value" lists are recognized automatically.
+func ReadSymbols(data []byte) ([]Symbol, error) {
+	var symbols []Symbol
+	dbg := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for row := 1; scanner.Scan(); row++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		var s Symbol
+		var ok bool
+		switch {
+		case strings.HasPrefix(line, "version\t"):
+			dbg = true
+			continue
+		case dbg:
+			if !strings.HasPrefix(line, "sym\t") {
+				continue
+			}
+			s, ok = parseDebugSymbol(line[4:])
+			if !ok {
+				continue
+			}
+		case strings.HasPrefix(line, "al "):
+			s, ok = parseVICELabel(line[3:])
+		default:
+			s, ok = parseSymbolAssignment(line)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid symbol definition", row)
+		}
+		symbols = append(symbols, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(symbols) == 0 {
+		return nil, errors.New("no symbols found")
+	}
+	return symbols, nil
+}
+
+// Parse the remainder of a VICE "al [C:]addr .name" label command.
+func parseVICELabel(s string) (Symbol, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Symbol{}, false
+	}
+	addr := fields[0]
+	if i := strings.IndexByte(addr, ':'); i >= 0 {
+		addr = addr[i+1:]
+	}
+	v, err := strconv.ParseUint(addr, 16, 16)
+	name := strings.TrimPrefix(fields[1], ".")
+	if err != nil || name == "" {
+		return Symbol{}, false
+	}
+	return Symbol{Name: name, Value: int(v), Label: true}, true
+}
+
+// Parse a "name = value" assignment. The value may be hexadecimal ($ or 0x
+// prefix), binary (% prefix) or decimal. The symbol isn't marked as a
+// label, since a plain list may hold constants as well as addresses.
+func parseSymbolAssignment(s string) (Symbol, bool) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return Symbol{}, false
+	}
+	name := strings.TrimSpace(s[:i])
+	value := strings.TrimSpace(s[i+1:])
+	if j := strings.IndexByte(value, ';'); j >= 0 {
+		value = strings.TrimSpace(value[:j])
+	}
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return Symbol{}, false
+	}
+
+	base := 10
+	switch {
+	case strings.HasPrefix(value, "$"):
+		value, base = value[1:], 16
+	case strings.HasPrefix(value, "0x"), strings.HasPrefix(value, "0X"):
+		value, base = value[2:], 16
+	case strings.HasPrefix(value, "%"):
+		value, base = value[1:], 2
+	}
+	v, err := strconv.ParseInt(value, base, 32)
+	if err != nil {
+		return Symbol{}, false
+	}
+	return Symbol{Name: name, Value: int(v)}, true
+}
+
+// Parse the attributes of an ld65 debug info "sym" record. Records without
+// a value, such as imports, are skipped.
+func parseDebugSymbol(s string) (Symbol, bool) {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key, rest := s[:i], s[i+1:]
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := 1
+			for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+				end++
+			}
+			if end == len(rest) {
+				return Symbol{}, false
+			}
+			unquoted, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return Symbol{}, false
+			}
+			value, rest = unquoted, rest[end+1:]
+		} else if j := strings.IndexByte(rest, ','); j >= 0 {
+			value, rest = rest[:j], rest[j:]
+		} else {
+			value, rest = rest, ""
+		}
+
+		attrs[key] = value
+		s = strings.TrimPrefix(rest, ",")
+	}
+
+	v, err := strconv.ParseInt(attrs["val"], 0, 32)
+	if err != nil || attrs["name"] == "" {
+		return Symbol{}, false
+	}
+	return Symbol{Name: attrs["name"], Value: int(v), Label: attrs["type"] == "lab"}, true
+}
@@ -13,6 +13,13 @@ func maxInt(a, b int) int {
 	return b
 }
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func hexchar(c byte) byte {
 	switch {
 	case c >= '0' && c <= '9':
@@ -27,6 +27,14 @@ type BrkHandler interface {
 	OnBrk(cpu *CPU)
 }
 
+// Hardware is an interface implemented by types that emulate devices
+// connected to the CPU. After each instruction is executed, Update is
+// called with the total number of elapsed CPU cycles, and it returns the
+// current state of the IRQ and NMI interrupt lines.
+type Hardware interface {
+	Update(cycles uint64) (irq, nmi bool)
+}
+
 // CPU represents a single 6502 CPU. It contains a pointer to the
 // memory associated with the CPU.
 type CPU struct {
@@ -40,6 +48,8 @@ type CPU struct {
 	deltaCycles int8
 	debugger    *Debugger
 	brkHandler  BrkHandler
+	hardware    Hardware
+	nmiLine     bool
 	storeByte   func(cpu *CPU, addr uint16, v byte)
 }
 
@@ -51,6 +61,9 @@ const (
 	vectorBRK   = 0xfffe
 )
 
+// Number of cycles consumed by the CPU when it responds to an interrupt.
+const interruptCycles = 7
+
 // NewCPU creates an emulated 6502 CPU bound to the specified memory.
 func NewCPU(arch Architecture, m Memory) *CPU {
 	/* LogFile, err := os.OpenFile("6502Emu.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -131,18 +144,49 @@ func (cpu *CPU) Step() {
 		cpu.Cycles += uint64(inst.BPCycles)
 	}
 
+	// Bring attached hardware up to date and service any interrupt it
+	// requests. NMI is edge-triggered, while IRQ is level-triggered.
+	if cpu.hardware != nil {
+		irq, nmi := cpu.hardware.Update(cpu.Cycles)
+		switch {
+		case nmi && !cpu.nmiLine:
+			cpu.nmi()
+			cpu.Cycles += interruptCycles
+		case irq && !cpu.Reg.InterruptDisable:
+			cpu.irq()
+			cpu.Cycles += interruptCycles
+		}
+		cpu.nmiLine = nmi
+	}
+
 	// Update the debugger so it handle breakpoints.
 	if cpu.debugger != nil {
 		cpu.debugger.onUpdatePC(cpu, cpu.Reg.PC)
 	}
 }
 
+// Reset performs the CPU's reset sequence. The registers are initialized,
+// interrupts are disabled, and execution continues at the address stored
+// in the reset vector.
+func (cpu *CPU) Reset() {
+	cpu.Reg.Init()
+	cpu.Reg.InterruptDisable = true
+	cpu.reset()
+}
+
 // AttachBrkHandler attaches a handler that is called whenever the BRK
 // instruction is executed.
 func (cpu *CPU) AttachBrkHandler(handler BrkHandler) {
 	cpu.brkHandler = handler
 }
 
+// AttachHardware attaches emulated hardware to the CPU. The hardware is
+// updated after every instruction, and its interrupt lines are serviced.
+func (cpu *CPU) AttachHardware(hw Hardware) {
+	cpu.hardware = hw
+	cpu.nmiLine = false
+}
+
 // AttachDebugger attaches a debugger to the CPU. The debugger receives
 // notifications whenever the CPU executes an instruction or stores a byte
 // to memory.
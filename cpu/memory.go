@@ -35,6 +35,24 @@ type Memory interface {
 	StoreAddress(addr uint16, v uint16)
 }
 
+// A Peeker is a Memory whose contents may also be read without side
+// effects. Memory with devices mapped into it implements Peeker, so that
+// tools such as the disassembler can inspect device registers without
+// disturbing them.
+type Peeker interface {
+	// PeekByte returns the byte at the address without side effects.
+	PeekByte(addr uint16) byte
+}
+
+// PeekByte returns the byte at the address. If the memory is a Peeker, the
+// byte is read without side effects.
+func PeekByte(m Memory, addr uint16) byte {
+	if p, ok := m.(Peeker); ok {
+		return p.PeekByte(addr)
+	}
+	return m.LoadByte(addr)
+}
+
 // FlatMemory represents an entire 16-bit address space as a singular
 // 64K buffer.
 type FlatMemory struct {
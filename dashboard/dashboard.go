@@ -13,8 +13,11 @@ package dashboard
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"image/color"
 	"os"
+	"strings"
 
 	//"log"
 	"time"
@@ -59,6 +62,7 @@ var (
 	fileButton            *widget.Button
 	themeButton           *widget.Button
 	currentTime           *widget.Label
+	clockRate             *widget.Label
 	mainContainer         *fyne.Container
 	buttonsContainer      *fyne.Container
 	settingsContainer     *fyne.Container
@@ -66,6 +70,11 @@ var (
 	registerContainer     *fyne.Container
 	consoleContainer      *container.Scroll
 	stackContainer        *fyne.Container
+	screenHeader          *widget.Label
+	screenGrid            *widget.TextGrid
+	screenContainer       *fyne.Container
+	screenName            string
+	screenVersion         uint64
 	centerContainer       *fyne.Container
 	middleContainer       *fyne.Container
 	fd                    *dialog.FileDialog
@@ -90,6 +99,7 @@ func New(cpu *cpu.CPU, host *host.Host) (w fyne.Window, o *bytes.Buffer) {
 	// Color backgrounds to be used in container stacks
 	registerBackground := canvas.NewRectangle(color.RGBA{R: 173, G: 219, B: 156, A: 200})
 	stackBackground := canvas.NewRectangle(color.RGBA{R: 173, G: 219, B: 156, A: 200})
+	screenBackground := canvas.NewRectangle(color.RGBA{R: 173, G: 219, B: 156, A: 200})
 	//consoleBackground := canvas.NewRectangle(color.RGBA{R: 223, G: 159, B: 173, A: 200})
 
 	// Control buttons
@@ -105,8 +115,9 @@ func New(cpu *cpu.CPU, host *host.Host) (w fyne.Window, o *bytes.Buffer) {
 	fileButton = widget.NewButton("File", func() { showFilePicker(w) })
 	assembleButton = widget.NewButton("Assemble", assembleSelectedFile)
 
-	// Display time
+	// Display time and the CPU's measured clock rate
 	currentTime = widget.NewLabel("")
+	clockRate = widget.NewLabel("")
 
 	// Command entry line
 	commandLine = widget.NewEntry()
@@ -168,6 +179,7 @@ func New(cpu *cpu.CPU, host *host.Host) (w fyne.Window, o *bytes.Buffer) {
 		exitButton,
 		helpButton,
 		currentTime,
+		clockRate,
 	)
 
 	settingsContainer = container.NewVBox(
@@ -182,12 +194,26 @@ func New(cpu *cpu.CPU, host *host.Host) (w fyne.Window, o *bytes.Buffer) {
 		stackContainer,
 	)
 
+	// Text display, shown while a text display device is mapped
+	screenHeader = widget.NewLabel("Text Display")
+	screenHeader.TextStyle.Monospace = true
+	screenHeader.TextStyle.Bold = true
+	screenGrid = widget.NewTextGrid()
+	screenContainer = container.NewStack(
+		screenBackground,
+		container.NewVBox(
+			screenHeader,
+			screenGrid,
+		))
+	screenContainer.Hide()
+
 	statusContainer = container.NewVBox(ConsoleScroller)
 	centerContainer = container.NewHBox(consoleContainer, stackContainer)
 
 	mainContainer = container.NewVBox(
 		settingsContainer,
 		middleContainer,
+		screenContainer,
 		statusContainer,
 	)
 
@@ -198,17 +224,38 @@ func New(cpu *cpu.CPU, host *host.Host) (w fyne.Window, o *bytes.Buffer) {
 	consoleContainer.Refresh()
 	UpdateAll()
 
+	// Redraw the text display ten times a second
+	go func() {
+		for range time.Tick(100 * time.Millisecond) {
+			UpdateScreen()
+		}
+	}()
+
+	// Refresh the display whenever the CPU starts or stops running
+	go func() {
+		for e := range h.Events() {
+			if !e.Running && e.Reason != host.StopStep {
+				SetStatus(fmt.Sprintf("CPU stopped at $%04X (%v).", e.PC, e.Reason))
+			}
+			UpdateAll()
+		}
+	}()
+
 	return w, &consoleBuffer
 }
 
 func UpdateAll() {
 
-	// Reload
+	// Reload, locking the host so the CPU can't change state while it is
+	// read.
+	h.Lock()
 	stackDisplay = c.GetStack()
-	stackLabelWidget.Text = stackDisplay
 	consoleDispString = consoleBuffer.String() // Get whatever is in the memory buffer from the host
-	consoleGridLabel.SetText(consoleDispString)
 	registerDisplay = c.GetRegisters()
+	h.Unlock()
+
+	stackLabelWidget.Text = stackDisplay
+	consoleGridLabel.SetText(consoleDispString)
 	registerDisplayWidget.Text = registerDisplay
 
 	// Refresh
@@ -280,27 +327,33 @@ func assembleSelectedFile() {
 }
 
 func run() {
+	if err := h.Start(context.Background()); err != nil {
+		SetStatus(err.Error())
+		return
+	}
 	SetStatus("Running program ...")
-	h.ProcessGUICmd("run")
-	UpdateAll()
 }
 
 func step() {
+	if err := h.Step(); err != nil {
+		SetStatus(err.Error())
+		return
+	}
 	SetStatus("Step in ...")
-	h.ProcessGUICmd("step in")
-	UpdateAll()
 }
 
 func reset() {
 	SetStatus("'Reset CPU.")
+	h.Pause()
+	h.Lock()
 	h.Reset()
+	h.Unlock()
 	UpdateAll()
 }
 
 func pause() {
 	SetStatus("Pause running program.")
-	h.Break()
-	UpdateAll()
+	h.Pause()
 }
 
 func exit() {
@@ -328,6 +381,42 @@ func submit() {
 func UpdateTime() {
 	formatted := time.Now().Format("Time: 15:05:01")
 	currentTime.SetText(formatted)
+	UpdateClockRate()
+}
+
+// Show the screen of the text display device, if there is one, and if it
+// has changed since it was last shown
+func UpdateScreen() {
+	s, ok := h.TextScreen()
+	if !ok {
+		if screenContainer.Visible() {
+			screenContainer.Hide()
+		}
+		return
+	}
+	if screenContainer.Visible() && s.Name == screenName && s.Version == screenVersion {
+		return
+	}
+
+	screenName, screenVersion = s.Name, s.Version
+	screenHeader.SetText("Text Display '" + s.Name + "'")
+	screenGrid.SetText(strings.Join(s.Lines, "\n"))
+	if s.CursorVisible {
+		screenGrid.SetStyle(s.CursorRow, s.CursorCol, &widget.CustomTextGridStyle{
+			FGColor: color.Black,
+			BGColor: color.White,
+		})
+	}
+	screenContainer.Show()
+}
+
+// Show the clock rate at which the CPU is actually running
+func UpdateClockRate() {
+	if mhz := h.MeasuredMHz(); mhz > 0 {
+		clockRate.SetText(fmt.Sprintf("%.3f MHz", mhz))
+	} else {
+		clockRate.SetText("CPU stopped")
+	}
 }
 
 func SetStatus(s string) {
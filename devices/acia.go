@@ -0,0 +1,352 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"io"
+	"sync"
+)
+
+// ACIA register offsets
+const (
+	aciaData    = 0x0 // transmit/receive data register
+	aciaStatus  = 0x1 // status register (write: programmed reset)
+	aciaCommand = 0x2 // command register
+	aciaControl = 0x3 // control register
+)
+
+// ACIA status register bits
+const (
+	aciaParityError  byte = 0x01
+	aciaFramingError byte = 0x02
+	aciaOverrun      byte = 0x04
+	aciaRDRF         byte = 0x08 // receive data register full
+	aciaTDRE         byte = 0x10 // transmit data register empty
+	aciaDCD          byte = 0x20 // data carrier detect (0 = detected)
+	aciaDSR          byte = 0x40 // data set ready (0 = ready)
+	aciaIRQ          byte = 0x80 // interrupt occurred
+)
+
+// ACIA command register fields
+const (
+	cmdDTR      byte = 0x01 // enable the receiver and interrupts
+	cmdIRD      byte = 0x02 // disable receiver interrupts
+	cmdTIC      byte = 0x0c // transmitter interrupt control
+	cmdEcho     byte = 0x10 // receiver echo mode
+	cmdParity   byte = 0x20 // parity enabled
+	cmdTICShift      = 2    // bit position of the transmitter control
+)
+
+// Transmitter control modes, from bits 2-3 of the command register
+const (
+	ticOff       = iota // transmitter off, interrupt disabled
+	ticInterrupt        // transmitter on, interrupt enabled
+	ticOn               // transmitter on, interrupt disabled
+	ticBreak            // transmitter sending break, interrupt disabled
+)
+
+// ACIA control register fields
+const (
+	ctlBaud       byte = 0x0f // baud rate select
+	ctlWordLength byte = 0x60 // word length select
+	ctlStopBits   byte = 0x80 // 2 stop bits
+	ctlWordShift       = 5    // bit position of the word length
+)
+
+// Baud rates selected by the control register when the ACIA is driven by
+// the standard 1.8432 MHz crystal. Rate 0 selects an external 16x clock,
+// which is emulated as an infinitely fast line.
+var aciaBaudRates = [16]float64{
+	0, 50, 75, 109.92, 134.58, 150, 300, 600,
+	1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200,
+}
+
+// An ACIA emulates a 6551/65C51 Asynchronous Communications Interface
+// Adapter. Characters take as long to send and receive as the programmed
+// baud rate and word format dictate at the CPU clock rate in ClockHz.
+//
+// The serial side of the ACIA is either attached to a byte stream with
+// Connect, or driven directly with Receive and the OnTransmit callback.
+// Received characters are queued and delivered only once the previous one
+// has been read, so no characters are lost when software is slower than
+// the line.
+type ACIA struct {
+	ClockHz    float64      // CPU clock rate used for baud-rate timing
+	OnTransmit func(b byte) // called when a character has been sent
+
+	rxData  byte   // receive data register
+	txData  byte   // transmit data register
+	txFull  bool   // transmit data register awaits the shifter
+	txShift bool   // a character is being shifted out
+	txByte  byte   // character being shifted out
+	txDone  uint64 // cycle at which the shifted character is sent
+	rxNext  uint64 // cycle at which the next character may arrive
+	status  byte   // status register, excluding the IRQ bit
+	command byte   // command register
+	control byte   // control register
+	irq     bool   // interrupt output asserted
+	cycles  uint64 // CPU cycle count at the last update
+
+	mu    sync.Mutex // guards rx and the connection state
+	rx    []byte     // characters waiting to be received
+	port  io.ReadWriteCloser
+	name  string
+	txOut chan byte
+}
+
+// NewACIA creates an ACIA in its reset state, timed for a 1 MHz CPU.
+func NewACIA() *ACIA {
+	a := &ACIA{ClockHz: 1000000}
+	a.Reset()
+	return a
+}
+
+// Reset puts the ACIA into its hardware reset state. Characters waiting to
+// be received are kept.
+func (a *ACIA) Reset() {
+	a.command = cmdIRD
+	a.control = 0
+	a.status = aciaTDRE
+	a.txFull, a.txShift = false, false
+	a.irq = false
+}
+
+// Size returns the number of ACIA registers.
+func (a *ACIA) Size() int {
+	return 4
+}
+
+// Read returns the value of an ACIA register, applying the side effects
+// of the read.
+func (a *ACIA) Read(reg int) byte {
+	return a.read(reg, false)
+}
+
+// Peek returns the value of an ACIA register without side effects.
+func (a *ACIA) Peek(reg int) byte {
+	return a.read(reg, true)
+}
+
+func (a *ACIA) read(reg int, peek bool) byte {
+	switch reg {
+	case aciaData:
+		if !peek {
+			a.status &^= aciaRDRF | aciaOverrun | aciaFramingError | aciaParityError
+		}
+		return a.rxData
+
+	case aciaStatus:
+		s := a.status
+		if a.irq {
+			s |= aciaIRQ
+		}
+		if !peek {
+			a.irq = false
+		}
+		return s
+
+	case aciaCommand:
+		return a.command
+
+	default:
+		return a.control
+	}
+}
+
+// Write stores a value into an ACIA register.
+func (a *ACIA) Write(reg int, b byte) {
+	switch reg {
+	case aciaData:
+		a.txData = b
+		a.txFull = true
+		a.status &^= aciaTDRE
+		a.startTransmit()
+
+	case aciaStatus:
+		// Programmed reset.
+		a.command &= 0xe0
+		a.status &^= aciaOverrun
+		a.irq = false
+
+	case aciaCommand:
+		a.command = b
+		a.startTransmit()
+
+	default:
+		a.control = b
+	}
+}
+
+// IRQ returns true while the ACIA is asserting its interrupt output.
+func (a *ACIA) IRQ() bool {
+	return a.irq && a.command&cmdDTR != 0
+}
+
+// Return the number of CPU cycles taken to send or receive a character.
+func (a *ACIA) charCycles() uint64 {
+	baud := aciaBaudRates[a.control&ctlBaud]
+	if baud == 0 {
+		return 0
+	}
+	bits := 1 + 8 - int(a.control&ctlWordLength)>>ctlWordShift + 1
+	if a.command&cmdParity != 0 {
+		bits++
+	}
+	if a.control&ctlStopBits != 0 {
+		bits++
+	}
+	return uint64(a.ClockHz * float64(bits) / baud)
+}
+
+// Return a character masked to the programmed word length.
+func (a *ACIA) mask(b byte) byte {
+	return b & (0xff >> (a.control & ctlWordLength >> ctlWordShift))
+}
+
+func (a *ACIA) transmitter() int {
+	return int(a.command&cmdTIC) >> cmdTICShift
+}
+
+// Move the transmit data register into the shifter if the transmitter is
+// on and idle.
+func (a *ACIA) startTransmit() {
+	if !a.txFull || a.txShift || a.transmitter() == ticOff {
+		return
+	}
+	a.txFull, a.txShift = false, true
+	a.txByte = a.mask(a.txData)
+	a.txDone = a.cycles + a.charCycles()
+	a.status |= aciaTDRE
+	if a.transmitter() == ticInterrupt {
+		a.irq = true
+	}
+}
+
+// Update advances the ACIA to the provided total CPU cycle count, sending
+// and receiving any characters whose time has come.
+func (a *ACIA) Update(cycles uint64) {
+	a.cycles = cycles
+
+	if a.txShift && cycles >= a.txDone {
+		a.txShift = false
+		a.transmit(a.txByte)
+		a.startTransmit()
+	}
+
+	if a.command&cmdDTR == 0 || a.status&aciaRDRF != 0 || cycles < a.rxNext {
+		return
+	}
+
+	a.mu.Lock()
+	if len(a.rx) == 0 {
+		a.mu.Unlock()
+		return
+	}
+	b := a.rx[0]
+	a.rx = a.rx[1:]
+	a.mu.Unlock()
+
+	a.rxData = a.mask(b)
+	a.status |= aciaRDRF
+	a.rxNext = cycles + a.charCycles()
+	if a.command&cmdIRD == 0 {
+		a.irq = true
+	}
+	if a.command&cmdEcho != 0 && a.transmitter() == ticOff {
+		a.transmit(a.rxData)
+	}
+}
+
+// Send a character out of the serial side of the ACIA.
+func (a *ACIA) transmit(b byte) {
+	if a.OnTransmit != nil {
+		a.OnTransmit(b)
+	}
+	a.mu.Lock()
+	if a.txOut != nil {
+		select {
+		case a.txOut <- b:
+		default:
+			// Drop characters nobody is reading, as a real line would.
+		}
+	}
+	a.mu.Unlock()
+}
+
+// Receive queues characters arriving on the serial side of the ACIA. It is
+// safe to call from any goroutine.
+func (a *ACIA) Receive(data []byte) {
+	a.mu.Lock()
+	a.rx = append(a.rx, data...)
+	a.mu.Unlock()
+}
+
+// Connect attaches the serial side of the ACIA to a byte stream, replacing
+// any existing connection. Characters read from the stream are received
+// and transmitted characters are written to it. The name describes the
+// connection and is reported by Connection.
+func (a *ACIA) Connect(port io.ReadWriteCloser, name string) {
+	a.Close()
+
+	txOut := make(chan byte, 4096)
+	a.mu.Lock()
+	a.port, a.name, a.txOut = port, name, txOut
+	a.mu.Unlock()
+
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := port.Read(buf)
+			if n > 0 {
+				a.Receive(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 0, 256)
+		for b := range txOut {
+			buf = append(buf[:0], b)
+			for more := true; more && len(buf) < cap(buf); {
+				select {
+				case b, ok := <-txOut:
+					if !ok {
+						more = false
+						break
+					}
+					buf = append(buf, b)
+				default:
+					more = false
+				}
+			}
+			port.Write(buf)
+		}
+	}()
+}
+
+// Connection returns the name of the byte stream the ACIA is connected
+// to, or an empty string if it isn't connected.
+func (a *ACIA) Connection() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.name
+}
+
+// Close disconnects the ACIA from its byte stream and closes the stream.
+func (a *ACIA) Close() error {
+	a.mu.Lock()
+	port, txOut := a.port, a.txOut
+	a.port, a.name, a.txOut = nil, "", nil
+	a.mu.Unlock()
+
+	if port == nil {
+		return nil
+	}
+	close(txOut)
+	return port.Close()
+}
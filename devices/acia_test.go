@@ -0,0 +1,157 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestACIARegisters(t *testing.T) {
+	a := NewACIA()
+	if got := a.Read(aciaStatus); got != aciaTDRE {
+		t.Errorf("status after reset $%02X, expected $10", got)
+	}
+
+	a.Write(aciaCommand, 0x0b)
+	a.Write(aciaControl, 0x1f)
+	if a.Read(aciaCommand) != 0x0b || a.Read(aciaControl) != 0x1f {
+		t.Error("command and control registers not stored")
+	}
+
+	// Programmed reset clears the low bits of the command register only.
+	a.Write(aciaCommand, 0xeb)
+	a.Write(aciaStatus, 0)
+	if got := a.Read(aciaCommand); got != 0xe0 {
+		t.Errorf("command after programmed reset $%02X, expected $E0", got)
+	}
+	if got := a.Read(aciaControl); got != 0x1f {
+		t.Errorf("control after programmed reset $%02X, expected $1F", got)
+	}
+}
+
+func TestACIATransmit(t *testing.T) {
+	a := NewACIA()
+	var sent []byte
+	a.OnTransmit = func(b byte) { sent = append(sent, b) }
+	a.Update(0)
+
+	// 9600 baud, 8N1: 10 bits at 1 MHz take 1041 cycles.
+	a.Write(aciaControl, 0x1e)
+	a.Write(aciaCommand, 0x0b)
+	a.Write(aciaData, 'A')
+	if a.Read(aciaStatus)&aciaTDRE == 0 {
+		t.Error("transmit register not emptied into the shifter")
+	}
+	a.Write(aciaData, 'B')
+	if a.Read(aciaStatus)&aciaTDRE != 0 {
+		t.Error("transmit register empty while the shifter is busy")
+	}
+
+	a.Update(1040)
+	if len(sent) != 0 {
+		t.Error("character sent before its time")
+	}
+	a.Update(1041)
+	if string(sent) != "A" || a.Read(aciaStatus)&aciaTDRE == 0 {
+		t.Errorf("sent %q, expected \"A\"", sent)
+	}
+	a.Update(2082)
+	if string(sent) != "AB" {
+		t.Errorf("sent %q, expected \"AB\"", sent)
+	}
+
+	// 7-bit words are masked, and the transmitter interrupt fires when the
+	// transmit register empties.
+	a.Write(aciaControl, 0x30)
+	a.Write(aciaCommand, 0x07)
+	a.Write(aciaData, 0xc1)
+	if !a.IRQ() || a.Read(aciaStatus)&aciaIRQ == 0 {
+		t.Error("transmitter interrupt not asserted")
+	}
+	if a.IRQ() {
+		t.Error("reading status did not clear the interrupt")
+	}
+	a.Update(2082)
+	if sent[2] != 0x41 {
+		t.Errorf("sent $%02X, expected $41", sent[2])
+	}
+}
+
+func TestACIAReceive(t *testing.T) {
+	a := NewACIA()
+	a.Update(0)
+	a.Receive([]byte("HI"))
+
+	// The receiver is disabled until DTR is set.
+	a.Update(10)
+	if a.Read(aciaStatus)&aciaRDRF != 0 {
+		t.Error("character received while the receiver was disabled")
+	}
+
+	a.Write(aciaControl, 0x1e)
+	a.Write(aciaCommand, 0x09)
+	a.Update(20)
+	if s := a.Read(aciaStatus); s&aciaRDRF == 0 || s&aciaIRQ == 0 {
+		t.Errorf("status $%02X, expected a received character and IRQ", s)
+	}
+	if got := a.Read(aciaData); got != 'H' {
+		t.Errorf("received %q, expected 'H'", got)
+	}
+	if a.Read(aciaStatus)&aciaRDRF != 0 {
+		t.Error("reading data did not clear RDRF")
+	}
+
+	// The next character arrives one character time later.
+	a.Update(1060)
+	if a.Read(aciaStatus)&aciaRDRF != 0 {
+		t.Error("second character received early")
+	}
+	a.Update(1061)
+	if a.Read(aciaStatus)&aciaRDRF == 0 || a.Read(aciaData) != 'I' {
+		t.Error("second character not received")
+	}
+}
+
+func TestACIATCP(t *testing.T) {
+	port, desc, err := OpenSerial("tcp:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewACIA()
+	a.Connect(port, desc)
+	defer a.Close()
+
+	conn, err := net.Dial("tcp", port.(*tcpPort).ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	a.Write(aciaCommand, 0x0b)
+	conn.Write([]byte("?"))
+
+	var cycles uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Read(aciaStatus)&aciaRDRF == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("character not received over TCP")
+		}
+		time.Sleep(time.Millisecond)
+		cycles++
+		a.Update(cycles)
+	}
+	b := a.Read(aciaData)
+
+	a.Write(aciaData, b+1)
+	a.Update(cycles + 1)
+	reply, err := bufio.NewReader(conn).ReadByte()
+	if err != nil || reply != '@' {
+		t.Errorf("TCP client read %q (%v), expected '@'", reply, err)
+	}
+}
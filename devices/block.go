@@ -0,0 +1,297 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SectorSize is the number of bytes in a block device sector.
+const SectorSize = 512
+
+// Block device register offsets
+const (
+	blkCommand = 0x0 // command (write) or status (read)
+	blkError   = 0x1 // error code of the last command
+	blkLBA0    = 0x2 // sector number, least significant byte
+	blkLBA1    = 0x3
+	blkLBA2    = 0x4
+	blkLBA3    = 0x5 // sector number, most significant byte
+	blkData    = 0x6 // sector buffer data port
+	blkIndex   = 0x7 // sector buffer position, in units of 2 bytes
+)
+
+// Block device commands
+const (
+	blkCmdClear = 0x00 // rewind the sector buffer and clear any error
+	blkCmdRead  = 0x01 // read the selected sector into the sector buffer
+	blkCmdWrite = 0x02 // write the sector buffer to the selected sector
+	blkCmdInfo  = 0x03 // fill the sector buffer with the disk's sector count
+)
+
+// Block device status register bits
+const (
+	blkStatusError    byte = 0x01 // the last command failed
+	blkStatusTransfer byte = 0x08 // sector buffer data remains
+	blkStatusReadOnly byte = 0x20 // the image is write protected
+	blkStatusReady    byte = 0x40 // an image is attached
+)
+
+// Block device error codes
+const (
+	blkErrNone       byte = iota // no error
+	blkErrNoMedia                // no image is attached
+	blkErrRange                  // the sector is beyond the end of the image
+	blkErrIO                     // the image file couldn't be read or written
+	blkErrReadOnly               // the image is write protected
+	blkErrBadCommand             // the command is unknown
+)
+
+// A BlockDevice is a simple mass storage controller that reads and writes
+// 512-byte sectors of a disk image file.
+//
+// Software selects a sector by writing its 32-bit number to the LBA
+// registers and then issues a command. Commands complete immediately. A
+// read fills the sector buffer, which is then read one byte at a time from
+// the data port. To write a sector, software clears the buffer, writes 512
+// bytes to the data port and issues a write command. Every command rewinds
+// the sector buffer. The info command fills the buffer with the number of
+// sectors on the disk as a 32-bit little-endian value.
+//
+// The registers are:
+//
+//	0  command (write), status (read)
+//	1  error code of the last command
+//	2  sector number bits 0-7
+//	3  sector number bits 8-15
+//	4  sector number bits 16-23
+//	5  sector number bits 24-31
+//	6  data port
+//	7  sector buffer position / 2
+type BlockDevice struct {
+	lba      [4]byte
+	buf      [SectorSize]byte
+	index    int  // position of the data port in the sector buffer
+	err      byte // error code of the last command
+	image    *os.File
+	path     string
+	readOnly bool
+	sectors  uint32
+}
+
+// NewBlockDevice creates a block device with no image attached.
+func NewBlockDevice() *BlockDevice {
+	return &BlockDevice{}
+}
+
+// Attach opens a disk image file and inserts it into the block device,
+// replacing any image already attached. The image is opened for reading
+// only if readOnly is true or the file can't be written. A partial sector
+// at the end of the image is ignored.
+func (b *BlockDevice) Attach(path string, readOnly bool) error {
+	var f *os.File
+	var err error
+	if !readOnly {
+		f, err = os.OpenFile(path, os.O_RDWR, 0)
+		if errors.Is(err, os.ErrPermission) {
+			readOnly = true
+		}
+	}
+	if readOnly {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi.Size() < SectorSize {
+		f.Close()
+		return fmt.Errorf("disk image '%s' is smaller than a sector", path)
+	}
+	if fi.Size()/SectorSize > 0xffffffff {
+		f.Close()
+		return fmt.Errorf("disk image '%s' is too large", path)
+	}
+
+	b.Detach()
+	b.image, b.path, b.readOnly = f, path, readOnly
+	b.sectors = uint32(fi.Size() / SectorSize)
+	return nil
+}
+
+// Detach closes the attached disk image, if any.
+func (b *BlockDevice) Detach() error {
+	if b.image == nil {
+		return nil
+	}
+	err := b.image.Close()
+	b.image, b.path, b.readOnly, b.sectors = nil, "", false, 0
+	return err
+}
+
+// Image returns the path of the attached disk image, or an empty string if
+// no image is attached.
+func (b *BlockDevice) Image() string {
+	return b.path
+}
+
+// Sectors returns the number of sectors in the attached disk image.
+func (b *BlockDevice) Sectors() uint32 {
+	return b.sectors
+}
+
+// Connection describes the attached disk image.
+func (b *BlockDevice) Connection() string {
+	if b.image == nil {
+		return ""
+	}
+	s := fmt.Sprintf("image %s (%d sectors", b.path, b.sectors)
+	if b.readOnly {
+		s += ", read-only"
+	}
+	return s + ")"
+}
+
+// Close detaches the disk image.
+func (b *BlockDevice) Close() error {
+	return b.Detach()
+}
+
+// Reset clears the registers and sector buffer. The disk image stays
+// attached.
+func (b *BlockDevice) Reset() {
+	b.lba = [4]byte{}
+	b.buf = [SectorSize]byte{}
+	b.index, b.err = 0, blkErrNone
+}
+
+// Size returns the number of block device registers.
+func (b *BlockDevice) Size() int {
+	return 8
+}
+
+// Read returns the value of a block device register. Reading the data port
+// advances to the next byte of the sector buffer.
+func (b *BlockDevice) Read(reg int) byte {
+	v := b.Peek(reg)
+	if reg == blkData && b.index < SectorSize {
+		b.index++
+	}
+	return v
+}
+
+// Peek returns the value of a block device register without side effects.
+func (b *BlockDevice) Peek(reg int) byte {
+	switch reg {
+	case blkCommand:
+		return b.status()
+	case blkError:
+		return b.err
+	case blkLBA0, blkLBA1, blkLBA2, blkLBA3:
+		return b.lba[reg-blkLBA0]
+	case blkData:
+		if b.index < SectorSize {
+			return b.buf[b.index]
+		}
+		return 0xff
+	default:
+		return byte(b.index / 2)
+	}
+}
+
+func (b *BlockDevice) status() byte {
+	var s byte
+	if b.err != blkErrNone {
+		s |= blkStatusError
+	}
+	if b.image != nil {
+		s |= blkStatusReady
+		if b.readOnly {
+			s |= blkStatusReadOnly
+		}
+	}
+	if b.index < SectorSize {
+		s |= blkStatusTransfer
+	}
+	return s
+}
+
+// Write stores a value into a block device register. Writing the command
+// register executes the command, and writing the data port stores the byte
+// at the current position in the sector buffer and advances.
+func (b *BlockDevice) Write(reg int, v byte) {
+	switch reg {
+	case blkCommand:
+		b.err = b.execute(v)
+		b.index = 0
+	case blkError:
+	case blkLBA0, blkLBA1, blkLBA2, blkLBA3:
+		b.lba[reg-blkLBA0] = v
+	case blkData:
+		if b.index < SectorSize {
+			b.buf[b.index] = v
+			b.index++
+		}
+	default:
+		b.index = int(v) * 2 % SectorSize
+	}
+}
+
+// Return the selected sector number.
+func (b *BlockDevice) sector() uint32 {
+	return uint32(b.lba[0]) | uint32(b.lba[1])<<8 | uint32(b.lba[2])<<16 | uint32(b.lba[3])<<24
+}
+
+// Execute a command, returning its error code.
+func (b *BlockDevice) execute(cmd byte) byte {
+	switch cmd {
+	case blkCmdClear:
+		return blkErrNone
+
+	case blkCmdRead, blkCmdWrite, blkCmdInfo:
+		if b.image == nil {
+			return blkErrNoMedia
+		}
+
+	default:
+		return blkErrBadCommand
+	}
+
+	if cmd == blkCmdInfo {
+		b.buf = [SectorSize]byte{}
+		s := b.sectors
+		b.buf[0], b.buf[1], b.buf[2], b.buf[3] = byte(s), byte(s>>8), byte(s>>16), byte(s>>24)
+		return blkErrNone
+	}
+
+	lba := b.sector()
+	if lba >= b.sectors {
+		return blkErrRange
+	}
+	off := int64(lba) * SectorSize
+
+	if cmd == blkCmdRead {
+		if _, err := b.image.ReadAt(b.buf[:], off); err != nil && err != io.EOF {
+			return blkErrIO
+		}
+		return blkErrNone
+	}
+
+	if b.readOnly {
+		return blkErrReadOnly
+	}
+	if _, err := b.image.WriteAt(b.buf[:], off); err != nil {
+		return blkErrIO
+	}
+	return blkErrNone
+}
@@ -0,0 +1,110 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a disk image of the requested number of sectors, each filled with
+// its sector number.
+func createImage(t *testing.T, sectors int) string {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	var data []byte
+	for i := 0; i < sectors; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, SectorSize)...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func selectSector(b *BlockDevice, lba uint32) {
+	b.Write(blkLBA0, byte(lba))
+	b.Write(blkLBA1, byte(lba>>8))
+	b.Write(blkLBA2, byte(lba>>16))
+	b.Write(blkLBA3, byte(lba>>24))
+}
+
+func TestBlockRead(t *testing.T) {
+	b := NewBlockDevice()
+	b.Write(blkCommand, blkCmdRead)
+	if b.Read(blkCommand)&blkStatusError == 0 || b.Read(blkError) != blkErrNoMedia {
+		t.Error("read without an image didn't fail")
+	}
+
+	if err := b.Attach(createImage(t, 4), false); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Detach()
+	if b.Sectors() != 4 {
+		t.Errorf("%d sectors, expected 4", b.Sectors())
+	}
+
+	selectSector(b, 2)
+	b.Write(blkCommand, blkCmdRead)
+	if got := b.Read(blkCommand); got != blkStatusReady|blkStatusTransfer {
+		t.Errorf("status after read $%02X, expected $48", got)
+	}
+	for i := 0; i < SectorSize; i++ {
+		if got := b.Read(blkData); got != 2 {
+			t.Fatalf("byte %d of sector 2 is $%02X", i, got)
+		}
+	}
+	if b.Read(blkCommand)&blkStatusTransfer != 0 {
+		t.Error("transfer still pending after reading the sector")
+	}
+
+	selectSector(b, 4)
+	b.Write(blkCommand, blkCmdRead)
+	if b.Read(blkError) != blkErrRange {
+		t.Error("read beyond the end of the image didn't fail")
+	}
+
+	b.Write(blkCommand, blkCmdInfo)
+	if b.Read(blkData) != 4 || b.Read(blkData) != 0 {
+		t.Error("info didn't report 4 sectors")
+	}
+}
+
+func TestBlockWrite(t *testing.T) {
+	path := createImage(t, 2)
+	b := NewBlockDevice()
+	if err := b.Attach(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	selectSector(b, 1)
+	b.Write(blkCommand, blkCmdClear)
+	for i := 0; i < SectorSize; i++ {
+		b.Write(blkData, byte(i))
+	}
+	b.Write(blkCommand, blkCmdWrite)
+	if b.Read(blkCommand)&blkStatusError != 0 {
+		t.Fatalf("write failed with error %d", b.Read(blkError))
+	}
+	b.Detach()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[SectorSize-1] != 0 || data[SectorSize] != 0 || data[2*SectorSize-1] != 0xff {
+		t.Error("sector 1 not written to the image")
+	}
+
+	if err := b.Attach(path, true); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Detach()
+	b.Write(blkCommand, blkCmdWrite)
+	if b.Read(blkError) != blkErrReadOnly {
+		t.Error("write to a read-only image didn't fail")
+	}
+}
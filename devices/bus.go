@@ -0,0 +1,251 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package devices implements peripheral chips that may be mapped into the
+// address space of an emulated 6502 CPU.
+package devices
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cjr29/go6502/cpu"
+)
+
+// Errors
+var (
+	ErrTooManyDevices = errors.New("too many mapped devices")
+)
+
+// A Device is a peripheral whose registers are accessed through a range of
+// memory addresses. Register offsets passed to the device are relative to
+// the start of its mapped range.
+type Device interface {
+	// Size returns the number of registers exposed by the device.
+	Size() int
+
+	// Read returns the value of a register, applying any side effects the
+	// read has on the device (such as clearing interrupt flags).
+	Read(reg int) byte
+
+	// Peek returns the value of a register without side effects. It is
+	// used by the debugger to display memory.
+	Peek(reg int) byte
+
+	// Write stores a value into a register.
+	Write(reg int, v byte)
+}
+
+// A Clocked device has state that changes as CPU cycles elapse.
+type Clocked interface {
+	// Update advances the device to the total number of elapsed CPU cycles.
+	Update(cycles uint64)
+}
+
+// An Interrupter is a device capable of requesting a CPU interrupt.
+type Interrupter interface {
+	// IRQ returns true while the device is asserting its interrupt output.
+	IRQ() bool
+}
+
+// Interrupt lines a device's interrupt output may be connected to
+const (
+	LineIRQ  = iota // maskable interrupt request
+	LineNMI         // non-maskable interrupt
+	LineNone        // not connected
+)
+
+// A Mapping describes a device mapped into the address space.
+type Mapping struct {
+	Name   string // Name used to identify the device
+	Addr   uint16 // First address of the mapped range
+	Size   int    // Number of addresses in the range
+	Line   int    // Interrupt line driven by the device
+	Device Device // The mapped device
+}
+
+// A Bus routes the CPU's memory accesses either to memory or to the
+// devices mapped into the address space. Device registers are mirrored
+// when a device is mapped into a range larger than its register count.
+type Bus struct {
+	mem      cpu.Memory
+	index    [64 * 1024]uint8 // 1-based mapping index for each address
+	mappings []*Mapping
+}
+
+// NewBus creates a bus that routes unmapped addresses to memory.
+func NewBus(mem cpu.Memory) *Bus {
+	return &Bus{mem: mem}
+}
+
+// Map maps a device into the address space starting at addr. The range
+// covers size addresses, or the device's register count if size is zero.
+// The device's interrupt output, if any, drives the IRQ line.
+func (b *Bus) Map(name string, addr uint16, size int, d Device) (*Mapping, error) {
+	if size <= 0 {
+		size = d.Size()
+	}
+	if int(addr)+size > len(b.index) {
+		return nil, fmt.Errorf("device '%s' extends beyond $FFFF", name)
+	}
+	if len(b.mappings) == 255 {
+		return nil, ErrTooManyDevices
+	}
+	for _, m := range b.mappings {
+		if m.Name == name {
+			return nil, fmt.Errorf("device '%s' is already mapped", name)
+		}
+		if int(addr) < int(m.Addr)+m.Size && int(m.Addr) < int(addr)+size {
+			return nil, fmt.Errorf("device '%s' overlaps device '%s'", name, m.Name)
+		}
+	}
+
+	m := &Mapping{Name: name, Addr: addr, Size: size, Line: LineIRQ, Device: d}
+	b.mappings = append(b.mappings, m)
+	for i := 0; i < size; i++ {
+		b.index[int(addr)+i] = uint8(len(b.mappings))
+	}
+	return m, nil
+}
+
+// Unmap removes the named device from the address space.
+func (b *Bus) Unmap(name string) error {
+	for i, m := range b.mappings {
+		if m.Name == name {
+			b.mappings = append(b.mappings[:i], b.mappings[i+1:]...)
+			b.reindex()
+			return nil
+		}
+	}
+	return fmt.Errorf("device '%s' not found", name)
+}
+
+// Rebuild the address index after the mapping list changes.
+func (b *Bus) reindex() {
+	b.index = [64 * 1024]uint8{}
+	for i, m := range b.mappings {
+		for j := 0; j < m.Size; j++ {
+			b.index[int(m.Addr)+j] = uint8(i + 1)
+		}
+	}
+}
+
+// Mappings returns all devices mapped into the address space, in the order
+// they were mapped.
+func (b *Bus) Mappings() []*Mapping {
+	return b.mappings
+}
+
+// Find returns the mapping for the named device, or nil if there is none.
+func (b *Bus) Find(name string) *Mapping {
+	for _, m := range b.mappings {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Return the device mapped at addr and the register it selects.
+func (b *Bus) device(addr uint16) (Device, int) {
+	i := b.index[addr]
+	if i == 0 {
+		return nil, 0
+	}
+	m := b.mappings[i-1]
+	return m.Device, int(addr-m.Addr) % m.Device.Size()
+}
+
+// LoadByte loads a single byte from the address and returns it.
+func (b *Bus) LoadByte(addr uint16) byte {
+	if d, reg := b.device(addr); d != nil {
+		return d.Read(reg)
+	}
+	return b.mem.LoadByte(addr)
+}
+
+// PeekByte returns the byte at the address without triggering any device
+// side effects.
+func (b *Bus) PeekByte(addr uint16) byte {
+	if d, reg := b.device(addr); d != nil {
+		return d.Peek(reg)
+	}
+	return b.mem.LoadByte(addr)
+}
+
+// LoadBytes loads multiple bytes from the address. Device registers are
+// peeked, so reading them this way has no side effects.
+func (b *Bus) LoadBytes(addr uint16, buf []byte) {
+	b.mem.LoadBytes(addr, buf)
+	for i := range buf {
+		a := int(addr) + i
+		if a >= len(b.index) {
+			break
+		}
+		if d, reg := b.device(uint16(a)); d != nil {
+			buf[i] = d.Peek(reg)
+		}
+	}
+}
+
+// LoadAddress loads a 16-bit address value from the requested address and
+// returns it. Like the NMOS 6502, the high byte of an address stored at
+// the end of a page is read from the start of the same page.
+func (b *Bus) LoadAddress(addr uint16) uint16 {
+	hi := addr + 1
+	if (addr & 0xff) == 0xff {
+		hi = addr - 0xff
+	}
+	return uint16(b.LoadByte(addr)) | uint16(b.LoadByte(hi))<<8
+}
+
+// StoreByte stores a byte to the requested address.
+func (b *Bus) StoreByte(addr uint16, v byte) {
+	if d, reg := b.device(addr); d != nil {
+		d.Write(reg, v)
+		return
+	}
+	b.mem.StoreByte(addr, v)
+}
+
+// StoreBytes stores multiple bytes to the requested address.
+func (b *Bus) StoreBytes(addr uint16, buf []byte) {
+	for i, v := range buf {
+		a := int(addr) + i
+		if a >= len(b.index) {
+			break
+		}
+		b.StoreByte(uint16(a), v)
+	}
+}
+
+// StoreAddress stores a 16-bit address value to the requested address.
+func (b *Bus) StoreAddress(addr uint16, v uint16) {
+	hi := addr + 1
+	if (addr & 0xff) == 0xff {
+		hi = addr - 0xff
+	}
+	b.StoreByte(addr, byte(v))
+	b.StoreByte(hi, byte(v>>8))
+}
+
+// Update advances all clocked devices to the total number of elapsed CPU
+// cycles and returns the combined state of their interrupt outputs. It
+// implements the cpu.Hardware interface.
+func (b *Bus) Update(cycles uint64) (irq, nmi bool) {
+	for _, m := range b.mappings {
+		if c, ok := m.Device.(Clocked); ok {
+			c.Update(cycles)
+		}
+		if i, ok := m.Device.(Interrupter); ok && i.IRQ() {
+			switch m.Line {
+			case LineNMI:
+				nmi = true
+			case LineIRQ:
+				irq = true
+			}
+		}
+	}
+	return irq, nmi
+}
@@ -0,0 +1,196 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+// Text display register offsets, relative to the end of screen memory
+const (
+	dispCursorCol = 0x0 // cursor column
+	dispCursorRow = 0x1 // cursor row
+	dispControl   = 0x2 // control register
+	dispCharOut   = 0x3 // character output
+)
+
+// Text display control register bits
+const (
+	dispCursorOn byte = 0x01 // show the cursor
+)
+
+// Characters with special meanings when written to the character output
+// register.
+const (
+	charBackspace = 0x08
+	charLineFeed  = 0x0a
+	charFormFeed  = 0x0c
+	charReturn    = 0x0d
+)
+
+// A TextDisplay is a memory-mapped character display. Its screen memory
+// holds one byte per character, row by row, starting at the mapped
+// address, and is followed by four registers:
+//
+//	+0  cursor column
+//	+1  cursor row
+//	+2  control (bit 0 shows the cursor)
+//	+3  character output
+//
+// Software may draw by storing characters directly into screen memory, or
+// print by writing them to the character output register. A printed
+// character is stored at the cursor, which then advances, scrolling the
+// screen up when it passes the bottom row. Printing a carriage return or
+// line feed moves the cursor to the start of the next line, a backspace
+// moves it back one column, and a form feed clears the screen.
+//
+// The display is rendered by the host, which compares Version numbers to
+// find out whether the screen has changed.
+type TextDisplay struct {
+	cols, rows int
+	screen     []byte
+	col, row   byte
+	control    byte
+	version    uint64
+}
+
+// NewTextDisplay creates a text display of the requested size, with a
+// blank screen and the cursor showing in its top left corner.
+func NewTextDisplay(cols, rows int) *TextDisplay {
+	d := &TextDisplay{cols: cols, rows: rows, screen: make([]byte, cols*rows)}
+	d.clear()
+	d.Reset()
+	return d
+}
+
+// Reset homes and shows the cursor. Screen memory is unchanged.
+func (d *TextDisplay) Reset() {
+	d.col, d.row = 0, 0
+	d.control = dispCursorOn
+	d.version++
+}
+
+// Size returns the number of bytes of screen memory plus the number of
+// registers.
+func (d *TextDisplay) Size() int {
+	return len(d.screen) + 4
+}
+
+// Columns returns the number of characters in each row.
+func (d *TextDisplay) Columns() int {
+	return d.cols
+}
+
+// Rows returns the number of rows on the screen.
+func (d *TextDisplay) Rows() int {
+	return d.rows
+}
+
+// Version returns a number that changes whenever the screen or cursor
+// does.
+func (d *TextDisplay) Version() uint64 {
+	return d.version
+}
+
+// Read returns the value of a screen memory location or register.
+func (d *TextDisplay) Read(reg int) byte {
+	return d.Peek(reg)
+}
+
+// Peek returns the value of a screen memory location or register.
+func (d *TextDisplay) Peek(reg int) byte {
+	if reg < len(d.screen) {
+		return d.screen[reg]
+	}
+	switch reg - len(d.screen) {
+	case dispCursorCol:
+		return d.col
+	case dispCursorRow:
+		return d.row
+	case dispControl:
+		return d.control
+	default:
+		return 0
+	}
+}
+
+// Write stores a value into a screen memory location or register.
+func (d *TextDisplay) Write(reg int, v byte) {
+	d.version++
+	if reg < len(d.screen) {
+		d.screen[reg] = v
+		return
+	}
+	switch reg - len(d.screen) {
+	case dispCursorCol:
+		d.col = v
+	case dispCursorRow:
+		d.row = v
+	case dispControl:
+		d.control = v
+	case dispCharOut:
+		d.print(v)
+	}
+}
+
+// Print a character at the cursor.
+func (d *TextDisplay) print(c byte) {
+	col, row := min(int(d.col), d.cols-1), min(int(d.row), d.rows-1)
+
+	switch c {
+	case charReturn, charLineFeed:
+		col, row = 0, row+1
+	case charBackspace:
+		if col > 0 {
+			col--
+		}
+	case charFormFeed:
+		d.clear()
+		col, row = 0, 0
+	default:
+		d.screen[row*d.cols+col] = c
+		if col++; col == d.cols {
+			col, row = 0, row+1
+		}
+	}
+
+	if row == d.rows {
+		copy(d.screen, d.screen[d.cols:])
+		for i := len(d.screen) - d.cols; i < len(d.screen); i++ {
+			d.screen[i] = ' '
+		}
+		row--
+	}
+	d.col, d.row = byte(col), byte(row)
+}
+
+// Fill the screen with spaces.
+func (d *TextDisplay) clear() {
+	for i := range d.screen {
+		d.screen[i] = ' '
+	}
+}
+
+// Lines returns the text on the screen, one string per row. Bit 7 of each
+// character is ignored, and control characters are shown as spaces.
+func (d *TextDisplay) Lines() []string {
+	lines := make([]string, d.rows)
+	buf := make([]byte, d.cols)
+	for r := range lines {
+		for c := range buf {
+			ch := d.screen[r*d.cols+c] & 0x7f
+			if ch < 0x20 || ch == 0x7f {
+				ch = ' '
+			}
+			buf[c] = ch
+		}
+		lines[r] = string(buf)
+	}
+	return lines
+}
+
+// Cursor returns the cursor's position and whether it is showing. A cursor
+// positioned off the screen is never showing.
+func (d *TextDisplay) Cursor() (col, row int, visible bool) {
+	col, row = int(d.col), int(d.row)
+	visible = d.control&dispCursorOn != 0 && col < d.cols && row < d.rows
+	return col, row, visible
+}
@@ -0,0 +1,60 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextDisplayMemory(t *testing.T) {
+	d := NewTextDisplay(40, 25)
+	if d.Size() != 40*25+4 {
+		t.Fatalf("size %d, expected %d", d.Size(), 40*25+4)
+	}
+
+	v := d.Version()
+	d.Write(41, 'H'|0x80)
+	d.Write(42, 'I')
+	if d.Version() == v {
+		t.Error("version unchanged by a write to screen memory")
+	}
+	if got := d.Lines()[1]; !strings.HasPrefix(got, " HI ") || len(got) != 40 {
+		t.Errorf("row 1 is %q", got)
+	}
+
+	d.Write(1000+dispCursorCol, 5)
+	d.Write(1000+dispCursorRow, 2)
+	if col, row, on := d.Cursor(); col != 5 || row != 2 || !on {
+		t.Errorf("cursor at %d,%d (showing %v), expected 5,2 showing", col, row, on)
+	}
+	d.Write(1000+dispControl, 0)
+	if _, _, on := d.Cursor(); on {
+		t.Error("cursor showing after being turned off")
+	}
+}
+
+func TestTextDisplayPrint(t *testing.T) {
+	d := NewTextDisplay(8, 3)
+	for _, c := range []byte("ABC\rDEFGHIJKLX\bY\nZ") {
+		d.Write(24+dispCharOut, c)
+	}
+
+	// The third line wrapped, scrolling the first line off the screen.
+	want := []string{"DEFGHIJK", "LY      ", "Z       "}
+	for i, line := range d.Lines() {
+		if line != want[i] {
+			t.Errorf("row %d is %q, expected %q", i, line, want[i])
+		}
+	}
+	if col, row, _ := d.Cursor(); col != 1 || row != 2 {
+		t.Errorf("cursor at %d,%d, expected 1,2", col, row)
+	}
+
+	d.Write(24+dispCharOut, charFormFeed)
+	if d.Lines()[0] != "        " || d.Peek(24+dispCursorRow) != 0 {
+		t.Error("form feed didn't clear the screen")
+	}
+}
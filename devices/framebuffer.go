@@ -0,0 +1,233 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"image"
+	"image/color"
+)
+
+// Framebuffer register offsets, relative to the end of pixel memory
+const (
+	fbPaletteIndex = 0x0 // palette entry selected for reading or writing
+	fbPaletteData  = 0x1 // red, green and blue of the selected entry
+	fbFrame        = 0x2 // frame counter
+)
+
+// A Framebuffer is a memory-mapped bitmap display of indexed pixels, each
+// 1, 2, 4 or 8 bits deep. Its pixel memory holds the image row by row,
+// starting at the mapped address, with each row beginning on a byte
+// boundary and the leftmost pixel of each byte in its most significant
+// bits. Pixel memory is followed by three registers:
+//
+//	+0  palette index
+//	+1  palette data
+//	+2  frame counter
+//
+// Each pixel value selects an entry of the palette, which has one entry
+// per possible value. Software changes an entry by writing its number to
+// the palette index register and then writing its red, green and blue
+// components, in that order, to the palette data register. The index
+// advances to the next entry after each blue component, so consecutive
+// entries may be written without selecting each. Reading the palette data
+// register returns the components in the same order.
+//
+// Software writes any value to the frame counter register when it has
+// finished drawing a frame. This advances the frame count, whose low byte
+// is returned by reading the register, and calls OnFrame, which lets the
+// host capture completed frames.
+type Framebuffer struct {
+	width, height int
+	depth         int // bits per pixel
+	stride        int // bytes per row
+	pixels        []byte
+	palette       []color.RGBA
+	index         byte // selected palette entry
+	component     int  // next palette component to read or write
+	frames        uint64
+
+	OnFrame func(n uint64) // called when frame n is complete
+}
+
+// NewFramebuffer creates a framebuffer of the requested size and depth in
+// bits per pixel, which must be 1, 2, 4 or 8. All pixels are set to color
+// 0, and the palette holds the default colors for the depth.
+func NewFramebuffer(width, height, depth int) *Framebuffer {
+	stride := (width*depth + 7) / 8
+	return &Framebuffer{
+		width:   width,
+		height:  height,
+		depth:   depth,
+		stride:  stride,
+		pixels:  make([]byte, stride*height),
+		palette: defaultPalette(depth),
+	}
+}
+
+// Reset rewinds the palette registers. Pixel memory, the palette and the
+// frame count are unchanged.
+func (f *Framebuffer) Reset() {
+	f.index, f.component = 0, 0
+}
+
+// Size returns the number of bytes of pixel memory plus the number of
+// registers.
+func (f *Framebuffer) Size() int {
+	return len(f.pixels) + 3
+}
+
+// Width returns the width of the image in pixels.
+func (f *Framebuffer) Width() int {
+	return f.width
+}
+
+// Height returns the height of the image in pixels.
+func (f *Framebuffer) Height() int {
+	return f.height
+}
+
+// Depth returns the number of bits per pixel.
+func (f *Framebuffer) Depth() int {
+	return f.depth
+}
+
+// Frames returns the number of frames completed so far.
+func (f *Framebuffer) Frames() uint64 {
+	return f.frames
+}
+
+// Read returns the value of a pixel memory location or register. Reading
+// the palette data register advances to the next palette component.
+func (f *Framebuffer) Read(reg int) byte {
+	v := f.Peek(reg)
+	if reg-len(f.pixels) == fbPaletteData {
+		f.advance()
+	}
+	return v
+}
+
+// Peek returns the value of a pixel memory location or register without
+// side effects.
+func (f *Framebuffer) Peek(reg int) byte {
+	if reg < len(f.pixels) {
+		return f.pixels[reg]
+	}
+	switch reg - len(f.pixels) {
+	case fbPaletteIndex:
+		return f.index
+	case fbPaletteData:
+		c := f.entry()
+		return [3]byte{c.R, c.G, c.B}[f.component]
+	default:
+		return byte(f.frames)
+	}
+}
+
+// Write stores a value into a pixel memory location or register.
+func (f *Framebuffer) Write(reg int, v byte) {
+	if reg < len(f.pixels) {
+		f.pixels[reg] = v
+		return
+	}
+	switch reg - len(f.pixels) {
+	case fbPaletteIndex:
+		f.index, f.component = v, 0
+	case fbPaletteData:
+		c := f.entry()
+		switch f.component {
+		case 0:
+			c.R = v
+		case 1:
+			c.G = v
+		default:
+			c.B = v
+		}
+		f.advance()
+	default:
+		f.frames++
+		if f.OnFrame != nil {
+			f.OnFrame(f.frames)
+		}
+	}
+}
+
+// Return the selected palette entry. Indexes beyond the end of the
+// palette wrap around to its start.
+func (f *Framebuffer) entry() *color.RGBA {
+	return &f.palette[int(f.index)%len(f.palette)]
+}
+
+// Advance to the next palette component, and to the next entry after the
+// blue component.
+func (f *Framebuffer) advance() {
+	if f.component++; f.component == 3 {
+		f.index, f.component = f.index+1, 0
+	}
+}
+
+// Image returns a copy of the framebuffer's contents as an image with one
+// byte per pixel.
+func (f *Framebuffer) Image() *image.Paletted {
+	palette := make(color.Palette, len(f.palette))
+	for i, c := range f.palette {
+		palette[i] = c
+	}
+	img := image.NewPaletted(image.Rect(0, 0, f.width, f.height), palette)
+
+	perByte := 8 / f.depth
+	mask := byte(1<<f.depth - 1)
+	for y := 0; y < f.height; y++ {
+		row := f.pixels[y*f.stride : (y+1)*f.stride]
+		for x := 0; x < f.width; x++ {
+			shift := 8 - f.depth*(x%perByte+1)
+			img.Pix[y*img.Stride+x] = row[x/perByte] >> shift & mask
+		}
+	}
+	return img
+}
+
+// The 16 colors of the CGA palette.
+var cgaPalette = []color.RGBA{
+	{0x00, 0x00, 0x00, 0xff}, {0x00, 0x00, 0xaa, 0xff},
+	{0x00, 0xaa, 0x00, 0xff}, {0x00, 0xaa, 0xaa, 0xff},
+	{0xaa, 0x00, 0x00, 0xff}, {0xaa, 0x00, 0xaa, 0xff},
+	{0xaa, 0x55, 0x00, 0xff}, {0xaa, 0xaa, 0xaa, 0xff},
+	{0x55, 0x55, 0x55, 0xff}, {0x55, 0x55, 0xff, 0xff},
+	{0x55, 0xff, 0x55, 0xff}, {0x55, 0xff, 0xff, 0xff},
+	{0xff, 0x55, 0x55, 0xff}, {0xff, 0x55, 0xff, 0xff},
+	{0xff, 0xff, 0x55, 0xff}, {0xff, 0xff, 0xff, 0xff},
+}
+
+// Return the default palette for a pixel depth: black and white for 1 bit,
+// four grays for 2 bits, the CGA colors for 4 bits, and for 8 bits the
+// CGA colors followed by a 6x6x6 color cube and a 24-step gray ramp.
+func defaultPalette(depth int) []color.RGBA {
+	switch depth {
+	case 1:
+		return []color.RGBA{{0x00, 0x00, 0x00, 0xff}, {0xff, 0xff, 0xff, 0xff}}
+	case 2:
+		return []color.RGBA{
+			{0x00, 0x00, 0x00, 0xff}, {0x55, 0x55, 0x55, 0xff},
+			{0xaa, 0xaa, 0xaa, 0xff}, {0xff, 0xff, 0xff, 0xff},
+		}
+	case 4:
+		return append([]color.RGBA(nil), cgaPalette...)
+	}
+
+	p := append([]color.RGBA(nil), cgaPalette...)
+	levels := []byte{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				p = append(p, color.RGBA{r, g, b, 0xff})
+			}
+		}
+	}
+	for i := 0; i < 24; i++ {
+		v := byte(8 + 10*i)
+		p = append(p, color.RGBA{v, v, v, 0xff})
+	}
+	return p
+}
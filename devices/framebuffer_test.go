@@ -0,0 +1,63 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestFramebufferPixels(t *testing.T) {
+	f := NewFramebuffer(6, 2, 2)
+	if f.Size() != 2*2+3 {
+		t.Fatalf("size %d, expected %d", f.Size(), 2*2+3)
+	}
+
+	// Row 1 starts on a byte boundary, with pixel 0 in the top bits.
+	f.Write(0, 0x1b)
+	f.Write(1, 0x80)
+	f.Write(2, 0xc0)
+	img := f.Image()
+	want := []byte{0, 1, 2, 3, 2, 0, 3, 0, 0, 0, 0, 0}
+	for i, v := range want {
+		x, y := i%6, i/6
+		if got := img.ColorIndexAt(x, y); got != v {
+			t.Errorf("pixel %d,%d is %d, expected %d", x, y, got, v)
+		}
+	}
+	if got := img.At(3, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("pixel 3,0 is %v, expected white", got)
+	}
+}
+
+func TestFramebufferPalette(t *testing.T) {
+	f := NewFramebuffer(8, 8, 8)
+	if n := len(f.Image().Palette); n != 256 {
+		t.Fatalf("palette has %d colors, expected 256", n)
+	}
+
+	reg := 64
+	f.Write(reg+fbPaletteIndex, 0xff)
+	for _, v := range []byte{1, 2, 3, 4, 5, 6} {
+		f.Write(reg+fbPaletteData, v)
+	}
+	p := f.Image().Palette
+	if p[255] != (color.RGBA{1, 2, 3, 0xff}) || p[0] != (color.RGBA{4, 5, 6, 0xff}) {
+		t.Errorf("palette entries 255 and 0 are %v and %v", p[255], p[0])
+	}
+
+	f.Write(reg+fbPaletteIndex, 0xff)
+	if r, g := f.Read(reg+fbPaletteData), f.Read(reg+fbPaletteData); r != 1 || g != 2 {
+		t.Errorf("read back components %d and %d, expected 1 and 2", r, g)
+	}
+
+	var frames []uint64
+	f.OnFrame = func(n uint64) { frames = append(frames, n) }
+	f.Write(reg+fbFrame, 0)
+	f.Write(reg+fbFrame, 0)
+	if len(frames) != 2 || frames[1] != 2 || f.Read(reg+fbFrame) != 2 {
+		t.Errorf("frames reported %v, counter %d", frames, f.Read(reg+fbFrame))
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/cjr29/go6502/devices
+
+go 1.21.6
+
+require (
+	github.com/cjr29/go6502/cpu v0.0.0
+	golang.org/x/sys v0.20.0
+)
+
+replace github.com/cjr29/go6502/cpu v0.0.0 => ../cpu
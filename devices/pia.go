@@ -0,0 +1,312 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+// PIA register offsets
+const (
+	piaDataA = 0x0 // peripheral register A or data direction register A
+	piaCRA   = 0x1 // control register A
+	piaDataB = 0x2 // peripheral register B or data direction register B
+	piaCRB   = 0x3 // control register B
+)
+
+// PIA control register fields
+const (
+	crC1Enable byte = 0x01 // C1 interrupt enable
+	crC1Rising byte = 0x02 // C1 active on a rising edge
+	crSelectOR byte = 0x04 // data register selects the peripheral register
+	crC2Mode   byte = 0x38 // C2 control
+	crIRQ2     byte = 0x40 // C2 interrupt flag
+	crIRQ1     byte = 0x80 // C1 interrupt flag
+	crC2Shift       = 3    // bit position of the C2 control
+)
+
+// C2 control modes, from bits 3-5 of a control register. Modes 0-3 make
+// C2 an input, with bit 0 enabling its interrupt and bit 1 selecting the
+// rising edge.
+const (
+	c2PIAInput     = 0
+	c2PIAEnable    = 1
+	c2PIARising    = 2
+	c2PIAHandshake = 4
+	c2PIAPulse     = 5
+	c2PIALow       = 6
+	c2PIAHigh      = 7
+)
+
+// One side of a PIA.
+type piaSide struct {
+	or, ddr, cr byte // peripheral, data direction and control registers
+	pins        byte // external input levels
+	out         byte // last reported output pin levels
+	c1, c2In    bool // C1 and C2 input levels
+	c2          bool // C2 output level
+	c2Pulse     bool // C2 returns high on the next update
+}
+
+func (s *piaSide) c2Mode() int {
+	return int(s.cr&crC2Mode) >> crC2Shift
+}
+
+func (s *piaSide) irq() bool {
+	return s.cr&crIRQ1 != 0 && s.cr&crC1Enable != 0 ||
+		s.cr&crIRQ2 != 0 && s.c2Mode()&c2PIAHandshake == 0 && s.c2Mode()&c2PIAEnable != 0
+}
+
+// A PIA emulates a 6821 Peripheral Interface Adapter. It provides two 8-bit
+// I/O ports with data direction registers, each with a pair of control
+// lines that can raise interrupts or handshake with a peripheral.
+//
+// Input pin levels are set by the emulated board using the SetPortA,
+// SetPortB, SetCA1, SetCA2, SetCB1 and SetCB2 methods. The On* callbacks,
+// if assigned, are called whenever the corresponding output changes.
+type PIA struct {
+	OnPortA func(v byte)  // called when port A output pins change
+	OnPortB func(v byte)  // called when port B output pins change
+	OnCA2   func(hi bool) // called when the CA2 output changes
+	OnCB2   func(hi bool) // called when the CB2 output changes
+
+	a, b piaSide
+}
+
+// NewPIA creates a PIA in its reset state, with all inputs pulled high.
+func NewPIA() *PIA {
+	p := &PIA{}
+	for _, s := range []*piaSide{&p.a, &p.b} {
+		s.pins, s.c1, s.c2In, s.c2 = 0xff, true, true, true
+	}
+	p.Reset()
+	return p
+}
+
+// Reset clears all PIA registers, as the chip's RESET input does.
+func (p *PIA) Reset() {
+	for _, s := range []*piaSide{&p.a, &p.b} {
+		s.or, s.ddr, s.cr = 0, 0, 0
+		s.c2Pulse = false
+	}
+	p.setC2(&p.a, true)
+	p.setC2(&p.b, true)
+	p.a.out, p.b.out = p.PortA(), p.PortB()
+}
+
+// Size returns the number of PIA registers.
+func (p *PIA) Size() int {
+	return 4
+}
+
+// Read returns the value of a PIA register, applying the side effects of
+// the read.
+func (p *PIA) Read(reg int) byte {
+	return p.read(reg, false)
+}
+
+// Peek returns the value of a PIA register without side effects.
+func (p *PIA) Peek(reg int) byte {
+	return p.read(reg, true)
+}
+
+func (p *PIA) read(reg int, peek bool) byte {
+	switch reg {
+	case piaDataA:
+		if p.a.cr&crSelectOR == 0 {
+			return p.a.ddr
+		}
+		if !peek {
+			p.a.cr &^= crIRQ1 | crIRQ2
+			p.handshake(&p.a)
+		}
+		return p.PortA()
+	case piaDataB:
+		if p.b.cr&crSelectOR == 0 {
+			return p.b.ddr
+		}
+		if !peek {
+			p.b.cr &^= crIRQ1 | crIRQ2
+		}
+		return p.b.or&p.b.ddr | p.b.pins&^p.b.ddr
+	case piaCRA:
+		return p.a.cr
+	default:
+		return p.b.cr
+	}
+}
+
+// Write stores a value into a PIA register.
+func (p *PIA) Write(reg int, v byte) {
+	switch reg {
+	case piaDataA:
+		if p.a.cr&crSelectOR == 0 {
+			p.a.ddr = v
+		} else {
+			p.a.or = v
+		}
+		p.notifyPorts()
+	case piaDataB:
+		if p.b.cr&crSelectOR == 0 {
+			p.b.ddr = v
+			p.notifyPorts()
+		} else {
+			p.b.or = v
+			p.notifyPorts()
+			p.handshake(&p.b)
+		}
+	case piaCRA:
+		p.writeCR(&p.a, v)
+	default:
+		p.writeCR(&p.b, v)
+	}
+}
+
+// Store a control register. The interrupt flags are read-only.
+func (p *PIA) writeCR(s *piaSide, v byte) {
+	s.cr = s.cr&(crIRQ1|crIRQ2) | v&^(crIRQ1|crIRQ2)
+	switch s.c2Mode() {
+	case c2PIALow:
+		p.setC2(s, false)
+	case c2PIAHandshake:
+	default:
+		p.setC2(s, true)
+	}
+}
+
+// Start a C2 handshake or pulse after port A is read or port B written.
+func (p *PIA) handshake(s *piaSide) {
+	switch s.c2Mode() {
+	case c2PIAHandshake:
+		p.setC2(s, false)
+	case c2PIAPulse:
+		p.setC2(s, false)
+		s.c2Pulse = true
+	}
+}
+
+// IRQ returns true while either of the PIA's interrupt outputs is
+// asserted.
+func (p *PIA) IRQ() bool {
+	return p.a.irq() || p.b.irq()
+}
+
+// PortA returns the levels of the port A pins. Output pins carry the
+// output register's value, and input pins carry their external levels.
+func (p *PIA) PortA() byte {
+	return p.a.or&p.a.ddr | p.a.pins&^p.a.ddr
+}
+
+// PortB returns the levels of the port B pins.
+func (p *PIA) PortB() byte {
+	return p.b.or&p.b.ddr | p.b.pins&^p.b.ddr
+}
+
+// CA2 returns the level of the CA2 output.
+func (p *PIA) CA2() bool {
+	return p.a.c2
+}
+
+// CB2 returns the level of the CB2 output.
+func (p *PIA) CB2() bool {
+	return p.b.c2
+}
+
+// SetPortA sets the external levels of the port A input pins.
+func (p *PIA) SetPortA(v byte) {
+	p.a.pins = v
+	p.notifyPorts()
+}
+
+// SetPortB sets the external levels of the port B input pins.
+func (p *PIA) SetPortB(v byte) {
+	p.b.pins = v
+	p.notifyPorts()
+}
+
+// SetCA1 sets the level of the CA1 input.
+func (p *PIA) SetCA1(hi bool) {
+	p.setC1(&p.a, hi)
+}
+
+// SetCB1 sets the level of the CB1 input.
+func (p *PIA) SetCB1(hi bool) {
+	p.setC1(&p.b, hi)
+}
+
+// SetCA2 sets the level of the CA2 line when it is an input.
+func (p *PIA) SetCA2(hi bool) {
+	p.setC2In(&p.a, hi)
+}
+
+// SetCB2 sets the level of the CB2 line when it is an input.
+func (p *PIA) SetCB2(hi bool) {
+	p.setC2In(&p.b, hi)
+}
+
+// Change a C1 input level. An active transition sets the C1 interrupt
+// flag and completes any C2 handshake.
+func (p *PIA) setC1(s *piaSide, hi bool) {
+	if s.c1 == hi {
+		return
+	}
+	s.c1 = hi
+	if hi == (s.cr&crC1Rising != 0) {
+		s.cr |= crIRQ1
+		if s.c2Mode() == c2PIAHandshake {
+			p.setC2(s, true)
+		}
+	}
+}
+
+// Change a C2 input level. An active transition sets the C2 interrupt
+// flag.
+func (p *PIA) setC2In(s *piaSide, hi bool) {
+	if s.c2In == hi {
+		return
+	}
+	s.c2In = hi
+	if m := s.c2Mode(); m&c2PIAHandshake == 0 && hi == (m&c2PIARising != 0) {
+		s.cr |= crIRQ2
+	}
+}
+
+// Update ends any C2 output pulses. Pulses last until the next update,
+// which follows the instruction that started them.
+func (p *PIA) Update(cycles uint64) {
+	for _, s := range []*piaSide{&p.a, &p.b} {
+		if s.c2Pulse {
+			s.c2Pulse = false
+			p.setC2(s, true)
+		}
+	}
+}
+
+// Set a C2 output level, reporting any change.
+func (p *PIA) setC2(s *piaSide, hi bool) {
+	if s.c2 == hi {
+		return
+	}
+	s.c2 = hi
+	fn := p.OnCA2
+	if s == &p.b {
+		fn = p.OnCB2
+	}
+	if fn != nil {
+		fn(hi)
+	}
+}
+
+// Report changes to the port output pins.
+func (p *PIA) notifyPorts() {
+	if a := p.PortA(); a != p.a.out {
+		p.a.out = a
+		if p.OnPortA != nil {
+			p.OnPortA(a)
+		}
+	}
+	if b := p.PortB(); b != p.b.out {
+		p.b.out = b
+		if p.OnPortB != nil {
+			p.OnPortB(b)
+		}
+	}
+}
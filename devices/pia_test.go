@@ -0,0 +1,100 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import "testing"
+
+func TestPIAPorts(t *testing.T) {
+	p := NewPIA()
+
+	// With the control register's bit 2 clear, the data register address
+	// selects the data direction register.
+	p.Write(piaDataB, 0x7f)
+	p.Write(piaCRB, crSelectOR)
+	p.Write(piaDataB, 0xc1)
+	p.SetPortB(0x00)
+	if got := p.Read(piaDataB); got != 0x41 {
+		t.Errorf("port B read $%02X, expected $41", got)
+	}
+	p.Write(piaCRB, 0)
+	if got := p.Read(piaDataB); got != 0x7f {
+		t.Errorf("DDRB read $%02X, expected $7F", got)
+	}
+
+	p.Write(piaCRA, crSelectOR)
+	p.SetPortA(0xd2)
+	if got := p.Read(piaDataA); got != 0xd2 {
+		t.Errorf("port A read $%02X, expected $D2", got)
+	}
+}
+
+func TestPIAInterrupts(t *testing.T) {
+	p := NewPIA()
+
+	// A falling CA1 edge sets the flag, but only raises IRQ when enabled.
+	p.Write(piaCRA, crSelectOR)
+	p.SetCA1(false)
+	if p.Read(piaCRA)&crIRQ1 == 0 || p.IRQ() {
+		t.Error("CA1 flag not set, or IRQ asserted while disabled")
+	}
+	p.Write(piaCRA, crSelectOR|crC1Enable|crIRQ1|crIRQ2)
+	if !p.IRQ() {
+		t.Error("enabling CA1 interrupts did not assert IRQ")
+	}
+	p.Read(piaDataA)
+	if p.IRQ() || p.Read(piaCRA)&crIRQ1 != 0 {
+		t.Error("reading port A did not clear the CA1 flag")
+	}
+
+	// A rising CB2 edge, when CB2 is an input selecting rising edges.
+	p.Write(piaCRB, crSelectOR|(c2PIAEnable|c2PIARising)<<crC2Shift)
+	p.SetCB2(false)
+	if p.IRQ() {
+		t.Error("falling CB2 edge asserted IRQ")
+	}
+	p.SetCB2(true)
+	if !p.IRQ() || p.Read(piaCRB)&crIRQ2 == 0 {
+		t.Error("rising CB2 edge did not assert IRQ")
+	}
+}
+
+func TestPIAHandshake(t *testing.T) {
+	p := NewPIA()
+	var strobes []byte
+	p.OnCB2 = func(hi bool) {
+		if !hi {
+			strobes = append(strobes, p.PortB())
+		}
+	}
+
+	// CB2 handshake: low after a port B write, high after a CB1 edge.
+	p.Write(piaDataB, 0xff)
+	p.Write(piaCRB, crSelectOR|crC1Rising|c2PIAHandshake<<crC2Shift)
+	p.Write(piaDataB, 'A')
+	if p.CB2() {
+		t.Error("CB2 not low after port B write")
+	}
+	p.Write(piaDataB, 'B')
+	p.SetCB1(false)
+	p.SetCB1(true)
+	if !p.CB2() {
+		t.Error("CB2 not high after CB1 edge")
+	}
+	p.Write(piaDataB, 'C')
+	if string(strobes) != "AC" {
+		t.Errorf("strobed %q, expected \"AC\"", strobes)
+	}
+
+	// CA2 pulse: low after a port A read until the next update.
+	p.Write(piaCRA, crSelectOR|c2PIAPulse<<crC2Shift)
+	p.Read(piaDataA)
+	if p.CA2() {
+		t.Error("CA2 not low after port A read")
+	}
+	p.Update(1)
+	if !p.CA2() {
+		t.Error("CA2 not high after the pulse")
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package devices
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+func openPipe(path string) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("named pipes are not supported on %s", runtime.GOOS)
+}
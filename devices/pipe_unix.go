@@ -0,0 +1,64 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package devices
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// A pipePort reads from one named pipe and writes to another.
+type pipePort struct {
+	in, out *os.File
+}
+
+// Open the named pipes <path>.in and <path>.out, creating them if they
+// don't exist.
+func openPipe(path string) (io.ReadWriteCloser, error) {
+	in, err := openFIFO(path + ".in")
+	if err != nil {
+		return nil, err
+	}
+	out, err := openFIFO(path + ".out")
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &pipePort{in: in, out: out}, nil
+}
+
+func openFIFO(path string) (*os.File, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := unix.Mkfifo(path, 0666); err != nil {
+			return nil, err
+		}
+	}
+
+	// Opening the pipe for both reading and writing keeps the open from
+	// blocking until a peer opens the other end, and keeps reads from
+	// reporting EOF whenever a peer closes it.
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+func (p *pipePort) Read(b []byte) (int, error) {
+	return p.in.Read(b)
+}
+
+func (p *pipePort) Write(b []byte) (int, error) {
+	return p.out.Write(b)
+}
+
+func (p *pipePort) Close() error {
+	err := p.in.Close()
+	if err2 := p.out.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// A ptyPort is the master side of a pseudo-terminal. The slave side is
+// held open so that reads don't fail while no program is attached to it.
+type ptyPort struct {
+	master, slave *os.File
+}
+
+// Open a new pseudo-terminal and return it along with the path of its
+// slave device.
+func openPTY() (io.ReadWriteCloser, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var n int
+	err = control(master, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		n, err = unix.IoctlGetInt(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	path := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	// Put the slave into raw mode so that the line discipline doesn't echo
+	// or translate characters until a program attaches and chooses its own
+	// settings.
+	err = control(slave, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB
+		t.Cflag |= unix.CS8
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, "", err
+	}
+
+	return &ptyPort{master: master, slave: slave}, path, nil
+}
+
+// Run fn with the file's descriptor without switching the file to blocking
+// mode, as File.Fd would.
+func control(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	err = rc.Control(func(fd uintptr) {
+		ferr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+func (p *ptyPort) Read(b []byte) (int, error) {
+	return p.master.Read(b)
+}
+
+func (p *ptyPort) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *ptyPort) Close() error {
+	p.slave.Close()
+	return p.master.Close()
+}
@@ -0,0 +1,17 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package devices
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+func openPTY() (io.ReadWriteCloser, string, error) {
+	return nil, "", fmt.Errorf("pseudo-terminals are not supported on %s", runtime.GOOS)
+}
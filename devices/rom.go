@@ -0,0 +1,34 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+// A ROM is a block of read-only memory. Writes to it are ignored.
+type ROM struct {
+	data []byte
+}
+
+// NewROM creates a ROM holding a copy of the data.
+func NewROM(data []byte) *ROM {
+	return &ROM{data: append([]byte(nil), data...)}
+}
+
+// Size returns the number of bytes in the ROM.
+func (r *ROM) Size() int {
+	return len(r.data)
+}
+
+// Read returns a byte from the ROM.
+func (r *ROM) Read(reg int) byte {
+	return r.data[reg]
+}
+
+// Peek returns a byte from the ROM.
+func (r *ROM) Peek(reg int) byte {
+	return r.data[reg]
+}
+
+// Write ignores attempts to store into the ROM.
+func (r *ROM) Write(reg int, v byte) {
+}
@@ -0,0 +1,27 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"testing"
+
+	"github.com/cjr29/go6502/cpu"
+)
+
+func TestROM(t *testing.T) {
+	mem := cpu.NewFlatMemory()
+	bus := NewBus(mem)
+	if _, err := bus.Map("rom", 0xfffc, 0, NewROM([]byte{0x00, 0xe0, 0x34, 0x12})); err != nil {
+		t.Fatal(err)
+	}
+
+	bus.StoreAddress(0xfffc, 0xabcd)
+	if got := bus.LoadAddress(0xfffc); got != 0xe000 {
+		t.Errorf("reset vector $%04X, expected $E000", got)
+	}
+	if got := bus.LoadAddress(0xfffe); got != 0x1234 {
+		t.Errorf("IRQ vector $%04X, expected $1234", got)
+	}
+}
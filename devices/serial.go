@@ -0,0 +1,129 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// OpenSerial opens a byte stream suitable for connecting to the serial side
+// of an ACIA. The spec selects the kind of stream:
+//
+//	pipe:<path>          named pipes <path>.in (input) and <path>.out (output)
+//	pty                  a new pseudo-terminal
+//	tcp:[<host>:]<port>  a TCP listener, on localhost unless a host is given
+//
+// It returns the stream along with a description of it, such as the path
+// of the pseudo-terminal's device file.
+func OpenSerial(spec string) (io.ReadWriteCloser, string, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch strings.ToLower(kind) {
+	case "pipe":
+		if arg == "" {
+			return nil, "", fmt.Errorf("missing pipe path in '%s'", spec)
+		}
+		p, err := openPipe(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		return p, "pipes " + arg + ".in and " + arg + ".out", nil
+
+	case "pty":
+		p, path, err := openPTY()
+		if err != nil {
+			return nil, "", err
+		}
+		return p, "pty " + path, nil
+
+	case "tcp":
+		if arg == "" {
+			return nil, "", fmt.Errorf("missing TCP port in '%s'", spec)
+		}
+		if !strings.Contains(arg, ":") {
+			arg = "127.0.0.1:" + arg
+		}
+		p, err := listenTCP(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		return p, "tcp " + p.ln.Addr().String(), nil
+
+	default:
+		return nil, "", fmt.Errorf("unknown serial connection '%s'", spec)
+	}
+}
+
+// A tcpPort is a byte stream served to one TCP client at a time. Reads
+// wait for a client to connect, and writes are discarded while no client
+// is connected.
+type tcpPort struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func listenTCP(addr string) (*tcpPort, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpPort{ln: ln}, nil
+}
+
+func (p *tcpPort) Read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		conn := p.conn
+		p.mu.Unlock()
+
+		if conn == nil {
+			c, err := p.ln.Accept()
+			if err != nil {
+				return 0, err
+			}
+			p.mu.Lock()
+			p.conn, conn = c, c
+			p.mu.Unlock()
+		}
+
+		n, err := conn.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+
+		// The client disconnected, so wait for the next one.
+		conn.Close()
+		p.mu.Lock()
+		if p.conn == conn {
+			p.conn = nil
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *tcpPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+	if conn != nil {
+		conn.Write(b)
+	}
+	return len(b), nil
+}
+
+func (p *tcpPort) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.mu.Unlock()
+	return err
+}
@@ -0,0 +1,605 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+// VIA register offsets
+const (
+	viaORB  = 0x0 // output/input register B
+	viaORA  = 0x1 // output/input register A (with handshake)
+	viaDDRB = 0x2 // data direction register B
+	viaDDRA = 0x3 // data direction register A
+	viaT1CL = 0x4 // timer 1 counter low
+	viaT1CH = 0x5 // timer 1 counter high
+	viaT1LL = 0x6 // timer 1 latch low
+	viaT1LH = 0x7 // timer 1 latch high
+	viaT2CL = 0x8 // timer 2 counter low
+	viaT2CH = 0x9 // timer 2 counter high
+	viaSR   = 0xa // shift register
+	viaACR  = 0xb // auxiliary control register
+	viaPCR  = 0xc // peripheral control register
+	viaIFR  = 0xd // interrupt flag register
+	viaIER  = 0xe // interrupt enable register
+	viaORAN = 0xf // output/input register A (no handshake)
+)
+
+// VIA interrupt flags
+const (
+	viaIntCA2 byte = 1 << iota
+	viaIntCA1
+	viaIntSR
+	viaIntCB2
+	viaIntCB1
+	viaIntT2
+	viaIntT1
+)
+
+// Auxiliary control register fields
+const (
+	acrLatchA     byte = 0x01 // latch port A inputs on CA1 edge
+	acrLatchB     byte = 0x02 // latch port B inputs on CB1 edge
+	acrShiftMode  byte = 0x1c // shift register mode
+	acrT2Count    byte = 0x20 // timer 2 counts PB6 pulses
+	acrT1FreeRun  byte = 0x40 // timer 1 free-running mode
+	acrT1PB7      byte = 0x80 // timer 1 drives PB7
+	acrShiftShift      = 2    // bit position of the shift register mode
+)
+
+// Shift register modes, from bits 2-4 of the auxiliary control register
+const (
+	srDisabled = iota
+	srInT2
+	srInPhi2
+	srInCB1
+	srOutFreeT2
+	srOutT2
+	srOutPhi2
+	srOutCB1
+)
+
+// Control line modes, from the CA2 (bits 1-3) and CB2 (bits 5-7) fields of
+// the peripheral control register
+const (
+	c2InNegative = iota
+	c2InNegativeIndependent
+	c2InPositive
+	c2InPositiveIndependent
+	c2Handshake
+	c2Pulse
+	c2Low
+	c2High
+)
+
+// A VIA emulates a W65C22 Versatile Interface Adapter. It provides two
+// 8-bit I/O ports with data direction registers, two 16-bit timers, a
+// shift register, four handshake/interrupt control lines, and an IRQ
+// output. Timers run from the CPU cycle count passed to Update.
+//
+// Input pin levels are set by the emulated board using the SetPortA,
+// SetPortB, SetCA1, SetCA2, SetCB1 and SetCB2 methods. The On* callbacks,
+// if assigned, are called whenever the corresponding output changes.
+type VIA struct {
+	OnPortA func(v byte)  // called when port A output pins change
+	OnPortB func(v byte)  // called when port B output pins change
+	OnCA2   func(hi bool) // called when the CA2 output changes
+	OnCB2   func(hi bool) // called when the CB2 output changes
+
+	ora, orb   byte // output registers
+	ddra, ddrb byte // data direction registers (1 = output)
+	pinsA      byte // external input levels on port A
+	pinsB      byte // external input levels on port B
+	ira, irb   byte // latched inputs
+	outA, outB byte // last reported output pin levels
+
+	t1c, t1l uint16 // timer 1 counter and latch
+	t1armed  bool   // timer 1 interrupt pending at next underflow
+	t1reload bool   // timer 1 reloads from latch on the next cycle
+	pb7      bool   // timer 1 PB7 output level
+	t2c      uint16 // timer 2 counter
+	t2ll     byte   // timer 2 latch low
+	t2armed  bool   // timer 2 interrupt pending at next underflow
+
+	sr       byte // shift register
+	srActive bool // shifting is in progress
+	srCount  int  // bits shifted so far
+	srTimer  int  // cycles until the next bit is shifted
+
+	acr, pcr byte // control registers
+	ifr, ier byte // interrupt flag and enable registers
+
+	ca1, cb1     bool // CA1 and CB1 input levels
+	ca2In, cb2In bool // CA2 and CB2 input levels
+	ca2, cb2     bool // CA2 and CB2 output levels
+	ca2Pulse     bool // CA2 returns high on the next cycle
+	cb2Pulse     bool // CB2 returns high on the next cycle
+
+	cycles  uint64 // CPU cycle count at the last update
+	started bool   // true once the cycle count is known
+}
+
+// NewVIA creates a VIA in its reset state, with all inputs pulled high.
+func NewVIA() *VIA {
+	v := &VIA{
+		pinsA: 0xff,
+		pinsB: 0xff,
+		ca1:   true,
+		cb1:   true,
+		ca2In: true,
+		cb2In: true,
+	}
+	v.Reset()
+	return v
+}
+
+// Reset clears the VIA's registers, as the chip's RES input does. The timer
+// counters, timer latches and shift register are unaffected.
+func (v *VIA) Reset() {
+	v.ora, v.orb, v.ddra, v.ddrb = 0, 0, 0, 0
+	v.acr, v.pcr, v.ifr, v.ier = 0, 0, 0, 0
+	v.t1armed, v.t1reload, v.t2armed = false, false, false
+	v.srActive, v.srCount = false, 0
+	v.pb7 = true
+	v.ca2, v.cb2 = true, true
+	v.ca2Pulse, v.cb2Pulse = false, false
+	v.outA, v.outB = v.PortA(), v.PortB()
+}
+
+// Size returns the number of VIA registers.
+func (v *VIA) Size() int {
+	return 16
+}
+
+// Read returns the value of a VIA register, applying the side effects of
+// the read.
+func (v *VIA) Read(reg int) byte {
+	return v.read(reg, false)
+}
+
+// Peek returns the value of a VIA register without side effects.
+func (v *VIA) Peek(reg int) byte {
+	return v.read(reg, true)
+}
+
+func (v *VIA) read(reg int, peek bool) byte {
+	switch reg {
+	case viaORB:
+		if !peek {
+			v.accessPortB(false)
+		}
+		in := v.pinsB
+		if v.acr&acrLatchB != 0 {
+			in = v.irb
+		}
+		return v.portB(v.orb&v.ddrb | in&^v.ddrb)
+	case viaORA, viaORAN:
+		if !peek && reg == viaORA {
+			v.accessPortA()
+		}
+		if v.acr&acrLatchA != 0 {
+			return v.ira
+		}
+		return v.PortA()
+	case viaDDRB:
+		return v.ddrb
+	case viaDDRA:
+		return v.ddra
+	case viaT1CL:
+		if !peek {
+			v.ifr &^= viaIntT1
+		}
+		return byte(v.t1c)
+	case viaT1CH:
+		return byte(v.t1c >> 8)
+	case viaT1LL:
+		return byte(v.t1l)
+	case viaT1LH:
+		return byte(v.t1l >> 8)
+	case viaT2CL:
+		if !peek {
+			v.ifr &^= viaIntT2
+		}
+		return byte(v.t2c)
+	case viaT2CH:
+		return byte(v.t2c >> 8)
+	case viaSR:
+		if !peek {
+			v.startShift()
+		}
+		return v.sr
+	case viaACR:
+		return v.acr
+	case viaPCR:
+		return v.pcr
+	case viaIFR:
+		if v.IRQ() {
+			return v.ifr | 0x80
+		}
+		return v.ifr
+	case viaIER:
+		return v.ier | 0x80
+	default:
+		return 0
+	}
+}
+
+// Write stores a value into a VIA register.
+func (v *VIA) Write(reg int, b byte) {
+	switch reg {
+	case viaORB:
+		v.orb = b
+		v.accessPortB(true)
+	case viaORA, viaORAN:
+		v.ora = b
+		if reg == viaORA {
+			v.accessPortA()
+		}
+	case viaDDRB:
+		v.ddrb = b
+	case viaDDRA:
+		v.ddra = b
+	case viaT1CL, viaT1LL:
+		v.t1l = v.t1l&0xff00 | uint16(b)
+	case viaT1CH:
+		v.t1l = v.t1l&0x00ff | uint16(b)<<8
+		v.t1c = v.t1l
+		v.t1armed, v.t1reload = true, false
+		v.ifr &^= viaIntT1
+		v.pb7 = false
+	case viaT1LH:
+		v.t1l = v.t1l&0x00ff | uint16(b)<<8
+		v.ifr &^= viaIntT1
+	case viaT2CL:
+		v.t2ll = b
+	case viaT2CH:
+		v.t2c = uint16(b)<<8 | uint16(v.t2ll)
+		v.t2armed = true
+		v.ifr &^= viaIntT2
+	case viaSR:
+		v.sr = b
+		v.startShift()
+	case viaACR:
+		v.acr = b
+		if v.shiftMode() == srDisabled {
+			v.srActive = false
+		}
+	case viaPCR:
+		v.pcr = b
+		v.setCA2(c2Output(v.ca2Mode(), v.ca2))
+		v.setCB2(c2Output(v.cb2Mode(), v.cb2))
+	case viaIFR:
+		v.ifr &^= b & 0x7f
+	case viaIER:
+		if b&0x80 != 0 {
+			v.ier |= b & 0x7f
+		} else {
+			v.ier &^= b & 0x7f
+		}
+	}
+	v.notifyPorts()
+}
+
+// IRQ returns true while the VIA is asserting its interrupt output.
+func (v *VIA) IRQ() bool {
+	return v.ifr&v.ier&0x7f != 0
+}
+
+// PortA returns the levels of the port A pins. Output pins carry the
+// output register's value, and input pins carry their external levels.
+func (v *VIA) PortA() byte {
+	return v.ora&v.ddra | v.pinsA&^v.ddra
+}
+
+// PortB returns the levels of the port B pins. When timer 1 drives PB7,
+// its output replaces bit 7.
+func (v *VIA) PortB() byte {
+	return v.portB(v.orb&v.ddrb | v.pinsB&^v.ddrb)
+}
+
+func (v *VIA) portB(b byte) byte {
+	if v.acr&acrT1PB7 != 0 {
+		b &^= 0x80
+		if v.pb7 {
+			b |= 0x80
+		}
+	}
+	return b
+}
+
+// CA2 returns the level of the CA2 output.
+func (v *VIA) CA2() bool {
+	return v.ca2
+}
+
+// CB2 returns the level of the CB2 output.
+func (v *VIA) CB2() bool {
+	return v.cb2
+}
+
+// SetPortA sets the external levels of the port A input pins.
+func (v *VIA) SetPortA(b byte) {
+	v.pinsA = b
+	v.notifyPorts()
+}
+
+// SetPortB sets the external levels of the port B input pins. When timer
+// 2 is in pulse counting mode, each falling edge on PB6 decrements it.
+func (v *VIA) SetPortB(b byte) {
+	falling := v.pinsB&0x40 != 0 && b&0x40 == 0
+	v.pinsB = b
+	if falling && v.acr&acrT2Count != 0 {
+		v.t2c--
+		if v.t2c == 0 && v.t2armed {
+			v.ifr |= viaIntT2
+			v.t2armed = false
+		}
+	}
+	v.notifyPorts()
+}
+
+// SetCA1 sets the level of the CA1 input. An active transition, as
+// selected by the peripheral control register, sets the CA1 interrupt
+// flag and latches port A if latching is enabled.
+func (v *VIA) SetCA1(hi bool) {
+	prev := v.ca1
+	v.ca1 = hi
+	if prev == hi || hi != (v.pcr&0x01 != 0) {
+		return
+	}
+	v.ifr |= viaIntCA1
+	v.ira = v.PortA()
+	if v.ca2Mode() == c2Handshake {
+		v.setCA2(true)
+	}
+}
+
+// SetCB1 sets the level of the CB1 input. An active transition sets the
+// CB1 interrupt flag and latches port B if latching is enabled. Rising
+// edges also clock the shift register when it uses an external clock.
+func (v *VIA) SetCB1(hi bool) {
+	prev := v.cb1
+	v.cb1 = hi
+	if prev == hi {
+		return
+	}
+	if hi && v.srActive {
+		if m := v.shiftMode(); m == srInCB1 || m == srOutCB1 {
+			v.shift()
+		}
+	}
+	if hi != (v.pcr&0x10 != 0) {
+		return
+	}
+	v.ifr |= viaIntCB1
+	v.irb = v.orb&v.ddrb | v.pinsB&^v.ddrb
+	if v.cb2Mode() == c2Handshake {
+		v.setCB2(true)
+	}
+}
+
+// SetCA2 sets the level of the CA2 line when it is configured as an input.
+func (v *VIA) SetCA2(hi bool) {
+	prev := v.ca2In
+	v.ca2In = hi
+	if m := v.ca2Mode(); m < c2Handshake && prev != hi && hi == (m >= c2InPositive) {
+		v.ifr |= viaIntCA2
+	}
+}
+
+// SetCB2 sets the level of the CB2 line when it is configured as an input.
+// The level is also the data shifted in by the shift register.
+func (v *VIA) SetCB2(hi bool) {
+	prev := v.cb2In
+	v.cb2In = hi
+	if m := v.cb2Mode(); m < c2Handshake && prev != hi && hi == (m >= c2InPositive) {
+		v.ifr |= viaIntCB2
+	}
+}
+
+// Update advances the VIA's timers and shift register to the provided
+// total CPU cycle count.
+func (v *VIA) Update(cycles uint64) {
+	if !v.started || cycles < v.cycles {
+		v.cycles, v.started = cycles, true
+		return
+	}
+	for ; v.cycles < cycles; v.cycles++ {
+		v.tick()
+	}
+}
+
+// Advance the VIA by a single clock cycle.
+func (v *VIA) tick() {
+	if v.ca2Pulse {
+		v.ca2Pulse = false
+		v.setCA2(true)
+	}
+	if v.cb2Pulse {
+		v.cb2Pulse = false
+		v.setCB2(true)
+	}
+
+	// Timer 1 counts down every cycle. In free-running mode it reloads
+	// from its latch one cycle after passing zero, for a period of N+2.
+	if v.t1reload {
+		v.t1c, v.t1reload = v.t1l, false
+	} else {
+		v.t1c--
+		if v.t1c == 0xffff {
+			v.timer1Expired()
+		}
+	}
+
+	// Timer 2 counts down every cycle unless it is counting PB6 pulses.
+	if v.acr&acrT2Count == 0 {
+		v.t2c--
+		if v.t2c == 0xffff && v.t2armed {
+			v.ifr |= viaIntT2
+			v.t2armed = false
+		}
+	}
+
+	if v.srActive && v.srTimer > 0 {
+		v.srTimer--
+		if v.srTimer == 0 {
+			v.shift()
+			v.srTimer = v.shiftPeriod()
+		}
+	}
+}
+
+// Handle timer 1 counting past zero.
+func (v *VIA) timer1Expired() {
+	freeRun := v.acr&acrT1FreeRun != 0
+	if v.t1armed {
+		v.ifr |= viaIntT1
+		if freeRun {
+			v.pb7 = !v.pb7
+		} else {
+			v.pb7 = true
+			v.t1armed = false
+		}
+		v.notifyPorts()
+	}
+	if freeRun {
+		v.t1reload = true
+	}
+}
+
+// Return the current shift register mode.
+func (v *VIA) shiftMode() int {
+	return int(v.acr&acrShiftMode) >> acrShiftShift
+}
+
+// Return the number of cycles between shifted bits, or 0 if the shift
+// register is clocked externally by CB1.
+func (v *VIA) shiftPeriod() int {
+	switch v.shiftMode() {
+	case srInT2, srOutFreeT2, srOutT2:
+		return 2 * (int(v.t2ll) + 2)
+	case srInPhi2, srOutPhi2:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Begin shifting 8 bits after the shift register is read or written.
+func (v *VIA) startShift() {
+	v.ifr &^= viaIntSR
+	if v.shiftMode() == srDisabled {
+		return
+	}
+	v.srActive, v.srCount = true, 0
+	v.srTimer = v.shiftPeriod()
+}
+
+// Shift a single bit into or out of the shift register. Bits are shifted
+// in from CB2, or shifted out to CB2 with the outgoing bit rotated back
+// into bit 0. After 8 bits, shifting stops and the SR interrupt flag is
+// set, except in free-running output mode, which shifts continuously.
+func (v *VIA) shift() {
+	mode := v.shiftMode()
+	if mode < srOutFreeT2 {
+		v.sr <<= 1
+		if v.cb2In {
+			v.sr |= 1
+		}
+	} else {
+		out := v.sr >> 7
+		v.sr = v.sr<<1 | out
+		v.setCB2(out != 0)
+	}
+
+	v.srCount++
+	if v.srCount == 8 {
+		v.srCount = 0
+		if mode != srOutFreeT2 {
+			v.srActive = false
+			v.ifr |= viaIntSR
+		}
+	}
+}
+
+// Return the CA2 and CB2 control line modes.
+func (v *VIA) ca2Mode() int { return int(v.pcr>>1) & 7 }
+func (v *VIA) cb2Mode() int { return int(v.pcr>>5) & 7 }
+
+// Return the output level of a control line after its mode changes.
+func c2Output(mode int, level bool) bool {
+	switch mode {
+	case c2Low:
+		return false
+	case c2High:
+		return true
+	case c2Handshake:
+		return level
+	default:
+		return true
+	}
+}
+
+// Handle a read or write of port A through the handshaking register.
+func (v *VIA) accessPortA() {
+	v.ifr &^= viaIntCA1
+	switch m := v.ca2Mode(); m {
+	case c2InNegative, c2InPositive:
+		v.ifr &^= viaIntCA2
+	case c2Handshake:
+		v.setCA2(false)
+	case c2Pulse:
+		v.setCA2(false)
+		v.ca2Pulse = true
+	}
+}
+
+// Handle a read or write of port B. CB2 handshaking and pulses are
+// triggered only by writes.
+func (v *VIA) accessPortB(write bool) {
+	v.ifr &^= viaIntCB1
+	switch m := v.cb2Mode(); {
+	case m == c2InNegative || m == c2InPositive:
+		v.ifr &^= viaIntCB2
+	case m == c2Handshake && write:
+		v.setCB2(false)
+	case m == c2Pulse && write:
+		v.setCB2(false)
+		v.cb2Pulse = true
+	}
+}
+
+// Set the CA2 output level, reporting any change.
+func (v *VIA) setCA2(hi bool) {
+	if v.ca2 != hi {
+		v.ca2 = hi
+		if v.OnCA2 != nil {
+			v.OnCA2(hi)
+		}
+	}
+}
+
+// Set the CB2 output level, reporting any change.
+func (v *VIA) setCB2(hi bool) {
+	if v.cb2 != hi {
+		v.cb2 = hi
+		if v.OnCB2 != nil {
+			v.OnCB2(hi)
+		}
+	}
+}
+
+// Report changes to the port output pins.
+func (v *VIA) notifyPorts() {
+	if a := v.PortA(); a != v.outA {
+		v.outA = a
+		if v.OnPortA != nil {
+			v.OnPortA(a)
+		}
+	}
+	if b := v.PortB(); b != v.outB {
+		v.outB = b
+		if v.OnPortB != nil {
+			v.OnPortB(b)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"testing"
+
+	"github.com/cjr29/go6502/cpu"
+)
+
+func newTestVIA() *VIA {
+	v := NewVIA()
+	v.Update(0)
+	return v
+}
+
+func TestVIAPorts(t *testing.T) {
+	v := newTestVIA()
+
+	var outA byte
+	v.OnPortA = func(b byte) { outA = b }
+
+	v.SetPortA(0x5a)
+	v.Write(viaDDRA, 0xf0)
+	v.Write(viaORA, 0x33)
+	if got := v.Read(viaORA); got != 0x3a {
+		t.Errorf("port A read $%02X, expected $3A", got)
+	}
+	if outA != 0x3a {
+		t.Errorf("port A output $%02X, expected $3A", outA)
+	}
+
+	v.Write(viaDDRB, 0xff)
+	v.Write(viaORB, 0x81)
+	if got := v.Read(viaORB); got != 0x81 || v.PortB() != 0x81 {
+		t.Errorf("port B read $%02X, expected $81", got)
+	}
+}
+
+func TestVIAInterruptRegisters(t *testing.T) {
+	v := newTestVIA()
+
+	v.Write(viaIER, 0x80|byte(viaIntT1|viaIntCA1))
+	if got := v.Read(viaIER); got != 0xc2 {
+		t.Errorf("IER read $%02X, expected $C2", got)
+	}
+	v.Write(viaIER, byte(viaIntCA1))
+	if got := v.Read(viaIER); got != 0xc0 {
+		t.Errorf("IER read $%02X, expected $C0", got)
+	}
+
+	// A negative CA1 edge sets its flag, but it is not enabled.
+	v.SetCA1(false)
+	if got := v.Read(viaIFR); got != byte(viaIntCA1) || v.IRQ() {
+		t.Errorf("IFR read $%02X, expected $02 with no IRQ", got)
+	}
+
+	// Reading port A clears the CA1 flag.
+	v.Read(viaORA)
+	if got := v.Read(viaIFR); got != 0 {
+		t.Errorf("IFR read $%02X, expected $00", got)
+	}
+}
+
+func TestVIATimer1(t *testing.T) {
+	v := newTestVIA()
+	v.Write(viaIER, 0x80|byte(viaIntT1))
+
+	// One-shot: interrupt N+1 cycles after the counter is loaded.
+	v.Write(viaT1CL, 10)
+	v.Write(viaT1CH, 0)
+	v.Update(10)
+	if v.IRQ() {
+		t.Error("timer 1 expired early")
+	}
+	v.Update(11)
+	if !v.IRQ() || v.Read(viaIFR) != 0xc0 {
+		t.Error("timer 1 did not expire")
+	}
+	v.Read(viaT1CL)
+	if v.IRQ() {
+		t.Error("reading T1C-L did not clear the interrupt")
+	}
+	v.Update(100)
+	if v.IRQ() {
+		t.Error("one-shot timer 1 expired twice")
+	}
+
+	// Free-running with PB7 output: period N+2, PB7 toggles each time.
+	v.Write(viaACR, acrT1FreeRun|acrT1PB7)
+	v.Write(viaT1CL, 8)
+	v.Write(viaT1CH, 0)
+	if v.PortB()&0x80 != 0 {
+		t.Error("PB7 not low after loading timer 1")
+	}
+	expiries := 0
+	for c := uint64(101); c <= 100+10*5; c++ {
+		v.Update(c)
+		if v.IRQ() {
+			expiries++
+			v.Read(viaT1CL)
+		}
+	}
+	if expiries != 5 {
+		t.Errorf("free-running timer 1 expired %d times, expected 5", expiries)
+	}
+	if v.PortB()&0x80 == 0 {
+		t.Error("PB7 not high after an odd number of expiries")
+	}
+}
+
+func TestVIATimer2(t *testing.T) {
+	v := newTestVIA()
+	v.Write(viaIER, 0x80|byte(viaIntT2))
+
+	v.Write(viaT2CL, 4)
+	v.Write(viaT2CH, 0)
+	v.Update(5)
+	if !v.IRQ() {
+		t.Error("timer 2 did not expire")
+	}
+	v.Read(viaT2CL)
+
+	// Pulse counting on PB6.
+	v.Write(viaACR, acrT2Count)
+	v.Write(viaT2CL, 3)
+	v.Write(viaT2CH, 0)
+	for i := 0; i < 3; i++ {
+		if v.IRQ() {
+			t.Fatalf("timer 2 expired after %d pulses", i)
+		}
+		v.SetPortB(0xbf)
+		v.SetPortB(0xff)
+	}
+	if !v.IRQ() {
+		t.Error("timer 2 did not expire after 3 pulses")
+	}
+}
+
+func TestVIAShiftRegister(t *testing.T) {
+	v := newTestVIA()
+	v.Write(viaIER, 0x80|byte(viaIntSR))
+
+	var bits []bool
+	v.OnCB2 = func(hi bool) { bits = append(bits, hi) }
+
+	// Shift out under the system clock: one bit every 2 cycles.
+	v.Write(viaACR, srOutPhi2<<acrShiftShift)
+	v.Write(viaSR, 0xa5)
+	v.Update(15)
+	if v.IRQ() {
+		t.Error("shift register finished early")
+	}
+	v.Update(16)
+	if !v.IRQ() || v.Peek(viaSR) != 0xa5 {
+		t.Errorf("shift register not finished (SR=$%02X)", v.Peek(viaSR))
+	}
+	if len(bits) == 0 || bits[0] {
+		t.Errorf("unexpected CB2 output %v", bits)
+	}
+
+	// Shift in under CB1 control.
+	v.Write(viaACR, srInCB1<<acrShiftShift)
+	v.Read(viaSR)
+	for i := 0; i < 8; i++ {
+		v.SetCB2(i%2 == 0)
+		v.SetCB1(false)
+		v.SetCB1(true)
+	}
+	if !v.IRQ() || v.Read(viaSR) != 0xaa {
+		t.Errorf("shift register read $%02X, expected $AA", v.Peek(viaSR))
+	}
+}
+
+func TestVIAHandshake(t *testing.T) {
+	v := newTestVIA()
+
+	// CA2 handshake output: low after port A access, high on CA1 edge.
+	v.Write(viaPCR, c2Handshake<<1)
+	v.Write(viaORA, 0x12)
+	if v.CA2() {
+		t.Error("CA2 not low after port A write")
+	}
+	v.SetCA1(false)
+	if !v.CA2() {
+		t.Error("CA2 not high after CA1 edge")
+	}
+
+	// CB2 pulse output: low for one cycle after port B write.
+	v.Write(viaPCR, c2Pulse<<5)
+	v.Write(viaORB, 0x34)
+	if v.CB2() {
+		t.Error("CB2 not low after port B write")
+	}
+	v.Update(1)
+	if !v.CB2() {
+		t.Error("CB2 not high one cycle after port B write")
+	}
+}
+
+func TestBusInterrupt(t *testing.T) {
+	mem := cpu.NewFlatMemory()
+	bus := NewBus(mem)
+	via := NewVIA()
+	if _, err := bus.Map("via", 0x6000, 0, via); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.Map("via2", 0x600f, 0, NewVIA()); err == nil {
+		t.Error("overlapping devices were mapped")
+	}
+
+	// Main program starts a free-running timer and loops. The interrupt
+	// handler counts interrupts at $00 and acknowledges them.
+	mem.StoreBytes(0x1000, []byte{
+		0xa9, 0x40, // LDA #$40
+		0x8d, 0x0b, 0x60, // STA $600B (ACR)
+		0xa9, 0xc0, // LDA #$C0
+		0x8d, 0x0e, 0x60, // STA $600E (IER)
+		0xa9, 0x30, // LDA #$30
+		0x8d, 0x04, 0x60, // STA $6004 (T1C-L)
+		0xa9, 0x00, // LDA #$00
+		0x8d, 0x05, 0x60, // STA $6005 (T1C-H)
+		0x58,             // CLI
+		0x4c, 0x15, 0x10, // JMP $1015
+	})
+	mem.StoreBytes(0x2000, []byte{
+		0xe6, 0x00, // INC $00
+		0xad, 0x04, 0x60, // LDA $6004
+		0x40, // RTI
+	})
+	mem.StoreAddress(0xfffe, 0x2000)
+
+	c := cpu.NewCPU(cpu.CMOS, bus)
+	c.AttachHardware(bus)
+	c.SetPC(0x1000)
+	for c.Cycles < 0x32*10 {
+		c.Step()
+	}
+
+	if n := mem.LoadByte(0x00); n < 8 || n > 10 {
+		t.Errorf("handled %d interrupts, expected about 10", n)
+	}
+}
@@ -46,6 +46,28 @@ var modeFormat = []string{
 
 var hex = "0123456789ABCDEF"
 
+// A SymbolTable maps memory addresses to symbol names. It is used to
+// display instruction operands and label lines symbolically.
+type SymbolTable map[uint16]string
+
+// The maximum offset from a symbol's address at which an operand is still
+// displayed relative to the symbol (e.g., BUFFER+2).
+const maxSymbolOffset = 8
+
+// Return the symbolic name of an address, either as an exact symbol match
+// or as a small positive offset from a preceding symbol.
+func (s SymbolTable) name(addr uint16) (string, bool) {
+	if name, ok := s[addr]; ok {
+		return name, true
+	}
+	for off := uint16(1); off <= maxSymbolOffset && off <= addr; off++ {
+		if name, ok := s[addr-off]; ok {
+			return fmt.Sprintf("%s+%d", name, off), true
+		}
+	}
+	return "", false
+}
+
 type Flags uint8
 
 const (
@@ -55,20 +77,33 @@ const (
 	ShowRegisters
 	ShowCycles
 	ShowAnnotations
+	ShowLabels
 
-	ShowBasic = ShowAddress | ShowCode | ShowInstruction | ShowAnnotations
-	ShowFull  = ShowAddress | ShowCode | ShowInstruction | ShowRegisters | ShowCycles
+	ShowBasic = ShowAddress | ShowCode | ShowInstruction | ShowAnnotations | ShowLabels
+	ShowFull  = ShowAddress | ShowCode | ShowInstruction | ShowRegisters | ShowCycles | ShowLabels
 )
 
 // Disassemble the machine code at memory address addr. Return a string
 // representing the disassembled instruction and the address of the next
 // instruction.
 func Disassemble(c *cpu.CPU, addr uint16, flags Flags, anno string, theme *Theme) (line string, next uint16) {
-	opcode := c.Mem.LoadByte(addr)
+	return DisassembleWithSymbols(c, addr, flags, anno, nil, theme)
+}
+
+// DisassembleWithSymbols behaves like Disassemble, but it displays operand
+// addresses found in the symbol table by name. If the ShowLabels flag is
+// set and the instruction's address has a symbol, the instruction is
+// preceded by a label line.
+func DisassembleWithSymbols(c *cpu.CPU, addr uint16, flags Flags, anno string, syms SymbolTable, theme *Theme) (line string, next uint16) {
+	opcode := peekByte(c, addr)
 	inst := c.InstSet.Lookup(opcode)
 	next = addr + uint16(inst.Length)
 	line = ""
 
+	if name, ok := syms[addr]; ok && (flags&ShowLabels) != 0 {
+		line += name + ":\n"
+	}
+
 	if (flags & ShowAddress) != 0 {
 		//line += fmt.Sprintf("%s%04X%s- ", theme.Addr, addr, theme.Reset)
 		line += fmt.Sprintf("%04X- ", addr)
@@ -76,7 +111,7 @@ func Disassemble(c *cpu.CPU, addr uint16, flags Flags, anno string, theme *Theme
 
 	if (flags & ShowCode) != 0 {
 		var csbuf [3]byte
-		c.Mem.LoadBytes(addr, csbuf[:next-addr])
+		peekBytes(c, addr, csbuf[:next-addr])
 		//line += fmt.Sprintf("%s%-8s%s  ", theme.Code, codeString(csbuf[:next-addr]), theme.Reset)
 		line += fmt.Sprintf("%-8s  ", codeString(csbuf[:next-addr]))
 	}
@@ -84,7 +119,7 @@ func Disassemble(c *cpu.CPU, addr uint16, flags Flags, anno string, theme *Theme
 	if (flags & ShowInstruction) != 0 {
 		var buf [2]byte
 		operand := buf[:inst.Length-1]
-		c.Mem.LoadBytes(addr+1, operand)
+		peekBytes(c, addr+1, operand)
 		if inst.Mode == cpu.REL {
 			// Convert relative offset to absolute address.
 			operand = buf[:]
@@ -95,11 +130,25 @@ func Disassemble(c *cpu.CPU, addr uint16, flags Flags, anno string, theme *Theme
 
 		// Return string composed of CPU instruction and operand.
 		//line += fmt.Sprintf("%s%s   %s"+modeFormat[inst.Mode]+"%s", theme.Inst, inst.Name, theme.Operand, hexString(operand), theme.Reset)
-		line += fmt.Sprintf("%s   "+modeFormat[inst.Mode], inst.Name, hexString(operand))
+		format, value := modeFormat[inst.Mode], hexString(operand)
+		if len(operand) > 0 && hasAddressOperand(inst.Mode) {
+			var operandAddr uint16
+			for i, b := range operand {
+				operandAddr |= uint16(b) << (8 * uint(i))
+			}
+			if name, ok := syms.name(operandAddr); ok {
+				format, value = strings.Replace(format, "$", "", 1), name
+			}
+		}
+		line += fmt.Sprintf("%s   "+format, inst.Name, value)
 
 		// Pad to next column using uncolorized version of the operand.
-		dummy := fmt.Sprintf(modeFormat[inst.Mode], hexString(operand))
-		line += strings.Repeat(" ", 9-len(dummy))
+		dummy := fmt.Sprintf(format, value)
+		if len(dummy) < 9 {
+			line += strings.Repeat(" ", 9-len(dummy))
+		} else {
+			line += " "
+		}
 	}
 
 	if (flags & ShowRegisters) != 0 {
@@ -164,6 +213,36 @@ func GetCompactRegisterString(r *cpu.Registers) string {
 	return fmt.Sprintf("A=%02X X=%02X Y=%02X PS=[%s]", r.A, r.X, r.Y, getStatusBits(r))
 }
 
+// Return the byte at the address. Memory is read without side effects, so
+// disassembling memory-mapped device registers doesn't disturb them.
+func peekByte(c *cpu.CPU, addr uint16) byte {
+	return cpu.PeekByte(c.Mem, addr)
+}
+
+// Fill the buffer with the bytes starting at the address, without side
+// effects.
+func peekBytes(c *cpu.CPU, addr uint16, b []byte) {
+	for i := range b {
+		b[i] = peekByte(c, addr+uint16(i))
+	}
+}
+
+// Return the little-endian 16-bit word at the address, without side
+// effects.
+func peekWord(c *cpu.CPU, addr uint16) uint16 {
+	return uint16(peekByte(c, addr)) | uint16(peekByte(c, addr+1))<<8
+}
+
+// Return true if the addressing mode's operand is a memory address.
+func hasAddressOperand(mode cpu.Mode) bool {
+	switch mode {
+	case cpu.IMM, cpu.IMP, cpu.ACC:
+		return false
+	default:
+		return true
+	}
+}
+
 func codeString(b []byte) string {
 	switch len(b) {
 	case 1:
@@ -0,0 +1,91 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cjr29/go6502/cpu"
+)
+
+func TestDisassembleWithSymbols(t *testing.T) {
+	mem := cpu.NewFlatMemory()
+	mem.StoreBytes(0x1000, []byte{
+		0xad, 0x02, 0x20, // LDA $2002
+		0xd0, 0xfb, // BNE $1000
+		0xa5, 0x10, // LDA $10
+		0x6c, 0x00, 0x20, // JMP ($2000)
+	})
+	c := cpu.NewCPU(cpu.NMOS, mem)
+	syms := SymbolTable{0x1000: "START", 0x2000: "BUF"}
+
+	tests := []struct {
+		addr  uint16
+		flags Flags
+		want  string
+	}{
+		{0x1000, ShowInstruction | ShowLabels, "START:\nLDA   BUF+2"},
+		{0x1000, ShowInstruction, "LDA   BUF+2"},
+		{0x1003, ShowInstruction | ShowLabels, "BNE   START"},
+		{0x1005, ShowInstruction | ShowLabels, "LDA   $10"},
+		{0x1007, ShowInstruction | ShowLabels, "JMP   (BUF)"},
+	}
+	for _, test := range tests {
+		line, _ := DisassembleWithSymbols(c, test.addr, test.flags, "", syms, nil)
+		if got := strings.TrimRight(line, " "); got != test.want {
+			t.Errorf("$%04X disassembled as %q, expected %q", test.addr, got, test.want)
+		}
+	}
+
+	if line, _ := Disassemble(c, 0x1003, ShowInstruction, "", nil); !strings.HasPrefix(line, "BNE   $1000") {
+		t.Errorf("$1003 disassembled without symbols as %q", line)
+	}
+}
+
+// Memory whose ordinary reads have side effects, like a device register
+// that is acknowledged by reading it.
+type ioMemory struct {
+	*cpu.FlatMemory
+	reads int
+}
+
+func (m *ioMemory) LoadByte(addr uint16) byte {
+	m.reads++
+	return m.FlatMemory.LoadByte(addr)
+}
+
+func (m *ioMemory) LoadBytes(addr uint16, b []byte) {
+	m.reads++
+	m.FlatMemory.LoadBytes(addr, b)
+}
+
+func (m *ioMemory) LoadAddress(addr uint16) uint16 {
+	m.reads++
+	return m.FlatMemory.LoadAddress(addr)
+}
+
+func (m *ioMemory) PeekByte(addr uint16) byte {
+	return m.FlatMemory.LoadByte(addr)
+}
+
+func TestDisassembleWithoutSideEffects(t *testing.T) {
+	mem := &ioMemory{FlatMemory: cpu.NewFlatMemory()}
+	mem.StoreBytes(0x10fe, []byte{0xad, 0x0d, 0x60, 0x4c, 0xfe, 0x10})
+	mem.StoreAddress(0xfffc, 0x10fe)
+	c := cpu.NewCPU(cpu.NMOS, mem)
+
+	line, _ := Disassemble(c, 0x10fe, ShowBasic, "", nil)
+	if !strings.Contains(line, "AD 0D 60") || !strings.Contains(line, "LDA   $600D") {
+		t.Errorf("unexpected disassembly %q", line)
+	}
+	if err := Export(io.Discard, c, 0x10fe, 0xffff, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mem.reads != 0 {
+		t.Errorf("disassembly read memory with side effects %d times", mem.reads)
+	}
+}
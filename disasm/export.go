@@ -0,0 +1,275 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cjr29/go6502/cpu"
+)
+
+// Classification of each byte in an exported address range
+const (
+	byteData    byte = iota // data or unreached code
+	byteOpcode              // first byte of an instruction
+	byteOperand             // operand byte of an instruction
+	byteWordLo              // low byte of an address word
+	byteWordHi              // high byte of an address word
+)
+
+// Interrupt vectors traced as entry points, with the names given to the
+// code they point to.
+var vectors = []struct {
+	addr uint16
+	name string
+}{
+	{0xfffa, "NMI"},
+	{0xfffc, "RESET"},
+	{0xfffe, "IRQ"},
+}
+
+// An exporter traces the code within an address range and writes it as
+// assembly source code.
+type exporter struct {
+	c      *cpu.CPU
+	start  int
+	end    int
+	kind   []byte
+	labels map[int]string
+}
+
+// Export disassembles the memory between the start and end addresses
+// (inclusive) and writes it to w as source code that the go6502 assembler
+// rebuilds into identical machine code. Code is found by tracing every
+// path of execution from the interrupt vectors and the provided entry
+// points; bytes that are never reached are written as data. Branch, jump
+// and subroutine targets are given labels. Memory is read without side
+// effects, so exporting a range that covers device registers is safe.
+func Export(w io.Writer, c *cpu.CPU, start, end uint16, entries []uint16) error {
+	if end < start {
+		return fmt.Errorf("invalid address range $%04X..$%04X", start, end)
+	}
+
+	e := &exporter{
+		c:      c,
+		start:  int(start),
+		end:    int(end),
+		kind:   make([]byte, int(end)-int(start)+1),
+		labels: make(map[int]string),
+	}
+
+	var pending []int
+	for _, v := range vectors {
+		target := int(peekWord(c, v.addr))
+		if e.markWord(int(v.addr)) && e.inRange(target) {
+			if _, ok := e.labels[target]; !ok {
+				e.labels[target] = v.name
+			}
+			pending = append(pending, target)
+		}
+	}
+	for _, entry := range entries {
+		if !e.inRange(int(entry)) {
+			return fmt.Errorf("entry point $%04X is outside $%04X..$%04X", entry, start, end)
+		}
+		e.addLabel(int(entry))
+		pending = append(pending, int(entry))
+	}
+
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = e.trace(addr, pending[:len(pending)-1])
+	}
+
+	return e.write(w)
+}
+
+// Return true if the address lies within the exported range.
+func (e *exporter) inRange(addr int) bool {
+	return addr >= e.start && addr <= e.end
+}
+
+// Give the address a generated label if it doesn't already have one.
+func (e *exporter) addLabel(addr int) {
+	if _, ok := e.labels[addr]; !ok && e.inRange(addr) {
+		e.labels[addr] = fmt.Sprintf("L%04X", addr)
+	}
+}
+
+// Mark the two bytes at addr as an address word if both lie within the
+// range and are not yet classified. Return true if the word lies within
+// the range.
+func (e *exporter) markWord(addr int) bool {
+	if !e.inRange(addr) || !e.inRange(addr+1) {
+		return false
+	}
+	i := addr - e.start
+	if e.kind[i] == byteData && e.kind[i+1] == byteData {
+		e.kind[i], e.kind[i+1] = byteWordLo, byteWordHi
+	}
+	return true
+}
+
+// Follow the path of execution starting at addr, classifying instruction
+// bytes, until the path leaves the range, reaches code that has already
+// been traced, or ends with an unconditional transfer of control. The
+// targets of branches and subroutine calls are added to the pending
+// list, which is returned.
+func (e *exporter) trace(addr int, pending []int) []int {
+	for e.inRange(addr) && e.kind[addr-e.start] == byteData {
+		inst := e.c.InstSet.Lookup(peekByte(e.c, uint16(addr)))
+		length := int(inst.Length)
+		if inst.Name == "???" || length == 0 || !e.inRange(addr+length-1) {
+			return pending
+		}
+		for i := 1; i < length; i++ {
+			if e.kind[addr-e.start+i] != byteData {
+				return pending
+			}
+		}
+
+		e.kind[addr-e.start] = byteOpcode
+		for i := 1; i < length; i++ {
+			e.kind[addr-e.start+i] = byteOperand
+		}
+
+		target := e.operandAddr(addr, inst)
+		switch {
+		case inst.Mode == cpu.REL:
+			e.addLabel(target)
+			if inst.Name == "BRA" {
+				addr = target
+				continue
+			}
+			pending = append(pending, target)
+
+		case inst.Name == "JSR":
+			e.addLabel(target)
+			pending = append(pending, target)
+
+		case inst.Name == "JMP" && inst.Mode == cpu.ABS:
+			e.addLabel(target)
+			addr = target
+			continue
+
+		case inst.Name == "JMP" && inst.Mode == cpu.IND:
+			if e.markWord(target) {
+				e.addLabel(target)
+				dest := int(peekWord(e.c, uint16(target)))
+				e.addLabel(dest)
+				pending = append(pending, dest)
+			}
+			return pending
+
+		case inst.Name == "JMP", inst.Name == "RTS", inst.Name == "RTI", inst.Name == "BRK":
+			return pending
+		}
+
+		addr += length
+	}
+	return pending
+}
+
+// Return the address or value encoded in an instruction's operand. For
+// relative branches, the branch target address is returned.
+func (e *exporter) operandAddr(addr int, inst *cpu.Instruction) int {
+	switch inst.Length {
+	case 2:
+		v := int(peekByte(e.c, uint16(addr+1)))
+		if inst.Mode == cpu.REL {
+			return (addr + 2 + byteToInt(byte(v))) & 0xffff
+		}
+		return v
+	case 3:
+		return int(peekWord(e.c, uint16(addr+1)))
+	default:
+		return 0
+	}
+}
+
+// Return the label for an address, or its hexadecimal representation if
+// it has no label that can be placed in the source code.
+func (e *exporter) name(addr int) string {
+	if label, ok := e.labels[addr]; ok {
+		switch e.kind[addr-e.start] {
+		case byteOperand, byteWordHi:
+		default:
+			return label
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// Write the traced range as assembly source code.
+func (e *exporter) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; Disassembly of $%04X..$%04X\n\n", e.start, e.end)
+	if e.c.InstSet.Arch == cpu.CMOS {
+		fmt.Fprintf(bw, "\t.arch 65c02\n")
+	}
+	fmt.Fprintf(bw, "\t.org $%04X\n\n", e.start)
+
+	for addr := e.start; addr <= e.end; {
+		kind := e.kind[addr-e.start]
+		if label, ok := e.labels[addr]; ok && kind != byteOperand && kind != byteWordHi {
+			fmt.Fprintf(bw, "%s:\n", label)
+		}
+
+		switch kind {
+		case byteOpcode:
+			inst := e.c.InstSet.Lookup(peekByte(e.c, uint16(addr)))
+			operand := e.operand(addr, inst)
+			if operand == "" {
+				fmt.Fprintf(bw, "\t%s\n", inst.Name)
+			} else {
+				fmt.Fprintf(bw, "\t%s %s\n", inst.Name, operand)
+			}
+			addr += int(inst.Length)
+
+		case byteWordLo:
+			fmt.Fprintf(bw, "\t.word %s\n", e.name(int(peekWord(e.c, uint16(addr)))))
+			addr += 2
+
+		default:
+			var values []string
+			for len(values) < 8 && addr <= e.end && e.kind[addr-e.start] == byteData {
+				if _, ok := e.labels[addr]; ok && len(values) > 0 {
+					break
+				}
+				values = append(values, fmt.Sprintf("$%02X", peekByte(e.c, uint16(addr))))
+				addr++
+			}
+			fmt.Fprintf(bw, "\t.byte %s\n", strings.Join(values, ","))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Return the source code representation of an instruction's operand.
+func (e *exporter) operand(addr int, inst *cpu.Instruction) string {
+	v := e.operandAddr(addr, inst)
+	switch {
+	case inst.Mode == cpu.IMP || inst.Mode == cpu.ACC:
+		return ""
+	case inst.Mode == cpu.IMM:
+		return fmt.Sprintf("#$%02X", v)
+	case inst.Mode == cpu.REL:
+		return e.name(v)
+	case inst.Length == 2:
+		return fmt.Sprintf(modeFormat[inst.Mode], fmt.Sprintf("%02X", v))
+	}
+
+	// Absolute operands use labels when possible, and zero-page addresses
+	// are forced to absolute addressing so the assembler preserves them.
+	operand := e.name(v)
+	if v < 0x100 && inst.Mode != cpu.IND {
+		operand = "A:" + operand
+	}
+	return fmt.Sprintf(strings.Replace(modeFormat[inst.Mode], "$", "", 1), operand)
+}
@@ -0,0 +1,99 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cjr29/go6502/asm"
+	"github.com/cjr29/go6502/cpu"
+)
+
+func TestExport(t *testing.T) {
+	src := `
+	.arch 65c02
+	.org $F000
+RESET:
+	LDX #$FF
+	TXS
+	LDA A:$0020
+	STA $20
+LOOP:
+	JSR PRINT
+	BCS LOOP
+	INC
+	BRA NEXT
+	.byte "HI", 0
+NEXT:
+	JMP (TABLE)
+PRINT:
+	LDA MSG,X
+	BEQ DONE
+	STA $D012
+	INX
+	BNE PRINT
+DONE:
+	SEC
+	RTS
+TABLE:
+	.word RESET
+MSG:
+	.byte "HELLO", 0
+IRQ:
+	RTI`
+
+	a, _, err := asm.Assemble(strings.NewReader(src), "test", 0x1000, &bytes.Buffer{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := cpu.NewFlatMemory()
+	mem.StoreBytes(a.Origin, a.Code)
+	for _, s := range a.Symbols {
+		switch s.Name {
+		case "RESET":
+			mem.StoreBytes(0xfffc, []byte{byte(s.Value), byte(s.Value >> 8)})
+		case "IRQ":
+			mem.StoreBytes(0xfffa, []byte{byte(s.Value), byte(s.Value >> 8)})
+			mem.StoreBytes(0xfffe, []byte{byte(s.Value), byte(s.Value >> 8)})
+		}
+	}
+	c := cpu.NewCPU(cpu.CMOS, mem)
+
+	var out bytes.Buffer
+	err = Export(&out, c, a.Origin, 0xffff, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+
+	for _, s := range []string{
+		"RESET:\n\tLDX #$FF\n",
+		"\tLDA A:$0020\n\tSTA $20\n",
+		"\tJSR L",
+		"\tBRA L",
+		"\t.byte $48,$49,$00\n",
+		"\tJMP (L",
+		"\t.word RESET\n",
+		"NMI:\n\tRTI\n",
+		"\t.word NMI\n\t.word RESET\n\t.word NMI\n",
+	} {
+		if !strings.Contains(exported, s) {
+			t.Errorf("export is missing %q:\n%s", s, exported)
+		}
+	}
+
+	b, _, err := asm.Assemble(strings.NewReader(exported), "export", 0x1000, &bytes.Buffer{}, 0)
+	if err != nil {
+		t.Fatalf("%v\n%s\n%s", err, strings.Join(b.Errors, "\n"), exported)
+	}
+	var original [0x1000]byte
+	mem.LoadBytes(a.Origin, original[:])
+	if b.Origin != a.Origin || !bytes.Equal(b.Code, original[:]) {
+		t.Errorf("reassembled code differs from the original:\n%s", exported)
+	}
+}
@@ -15,6 +15,7 @@ require (
 	github.com/beevik/cmd v0.2.0 // indirect
 	github.com/beevik/prefixtree v0.3.0 // indirect
 	github.com/cjr29/go6502/cpu v0.0.0 // indirect
+	github.com/cjr29/go6502/devices v0.0.0 // indirect
 	github.com/cjr29/go6502/disasm v0.0.0 // indirect
 	github.com/cjr29/go6502/term v0.0.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -60,3 +61,5 @@ replace github.com/cjr29/go6502/asm v0.0.0 => ./asm
 replace github.com/cjr29/go6502/term v0.0.0 => ./term
 
 replace github.com/cjr29/go6502/disasm v0.0.0 => ./disasm
+
+replace github.com/cjr29/go6502/devices v0.0.0 => ./devices
@@ -0,0 +1,167 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/cjr29/go6502/devices"
+	"github.com/cjr29/go6502/term"
+)
+
+const (
+	// Execution is paced in batches, sleeping only once the CPU is at least
+	// this far ahead of wall-clock time.
+	paceMinSleep = time.Millisecond
+
+	// If the CPU falls this far behind wall-clock time, because the host is
+	// too slow or the CPU was stopped, pacing starts over rather than
+	// running flat out to catch up.
+	paceMaxLag = 50 * time.Millisecond
+
+	// Interval between measurements of the CPU's actual clock rate.
+	measureInterval = 500 * time.Millisecond
+
+	// Clock rate assumed by devices when no clock rate is set.
+	defaultClockHz = 1000000
+)
+
+// A clock paces the emulated CPU against wall-clock time and measures the
+// rate at which it actually runs.
+type clock struct {
+	hz           int       // clock rate being paced, or 0 if unthrottled
+	begin        time.Time // wall-clock time at which the CPU started running
+	beginCycles  uint64    // CPU cycle count at which the CPU started running
+	start        time.Time // wall-clock time at which pacing began
+	startCycles  uint64    // CPU cycle count at which pacing began
+	sampleTime   time.Time // wall-clock time of the last measurement
+	sampleCycles uint64    // CPU cycle count at the last measurement
+	mhz          atomic.Uint64
+}
+
+// Start pacing and measuring from the provided CPU cycle count.
+func (c *clock) reset(cycles uint64) {
+	now := time.Now()
+	c.begin, c.beginCycles = now, cycles
+	c.start, c.startCycles = now, cycles
+	c.sampleTime, c.sampleCycles = now, cycles
+}
+
+// Return how long to wait for wall-clock time to catch up with the CPU
+// cycle count at the provided clock rate. A rate of 0 runs the CPU
+// unthrottled.
+func (c *clock) delay(cycles uint64, hz int) time.Duration {
+	now := time.Now()
+	if hz != c.hz {
+		c.hz = hz
+		c.start, c.startCycles = now, cycles
+	}
+	if hz <= 0 {
+		return 0
+	}
+
+	due := time.Duration(float64(cycles-c.startCycles) * float64(time.Second) / float64(hz))
+	switch ahead := due - now.Sub(c.start); {
+	case ahead >= paceMinSleep:
+		return ahead
+	case ahead < -paceMaxLag:
+		c.start, c.startCycles = now, cycles
+	}
+	return 0
+}
+
+// Update the measured clock rate if a measurement interval has passed
+// since the last one. It returns true if the rate was updated.
+func (c *clock) measure(cycles uint64) bool {
+	now := time.Now()
+	elapsed := now.Sub(c.sampleTime)
+	if elapsed < measureInterval {
+		return false
+	}
+	mhz := float64(cycles-c.sampleCycles) / elapsed.Seconds() / 1e6
+	c.mhz.Store(math.Float64bits(mhz))
+	c.sampleTime, c.sampleCycles = now, cycles
+	return true
+}
+
+// Stop measuring, returning the number of cycles the CPU ran since the
+// clock was reset and how long that took.
+func (c *clock) stop(cycles uint64) (uint64, time.Duration) {
+	c.mhz.Store(0)
+	return cycles - c.beginCycles, time.Since(c.begin)
+}
+
+// Return the most recently measured clock rate in MHz.
+func (c *clock) rate() float64 {
+	return math.Float64frombits(c.mhz.Load())
+}
+
+// MeasuredMHz returns the clock rate in MHz at which the CPU is actually
+// running, measured over the last half second. It returns 0 while the CPU
+// isn't running.
+func (h *Host) MeasuredMHz() float64 {
+	return h.clock.rate()
+}
+
+// Return the CPU clock rate in Hz that devices use for their timing.
+func (h *Host) clockRate() float64 {
+	if h.settings.ClockHz > 0 {
+		return float64(h.settings.ClockHz)
+	}
+	return defaultClockHz
+}
+
+// Return the clock rate in Hz at which execution is paced, or 0 if the CPU
+// runs unthrottled.
+func (h *Host) pacingRate() int {
+	if h.settings.Turbo {
+		return 0
+	}
+	return h.settings.ClockHz
+}
+
+// Describe the clock rate at which the CPU runs.
+func (h *Host) clockDesc() string {
+	switch hz := h.pacingRate(); {
+	case hz > 0:
+		return fmt.Sprintf("%.3f MHz", float64(hz)/1e6)
+	case h.settings.Turbo && h.settings.ClockHz > 0:
+		return "full speed (turbo)"
+	default:
+		return "full speed"
+	}
+}
+
+// Apply the clock rate setting to devices whose timing depends on it.
+func (h *Host) updateDeviceClocks() {
+	for _, m := range h.bus.Mappings() {
+		if a, ok := m.Device.(*devices.ACIA); ok {
+			a.ClockHz = h.clockRate()
+		}
+	}
+}
+
+// Show the measured clock rate on the console's status line, which is
+// overwritten by the next update and erased before any other output. The
+// status line is shown only on an interactive console that no device is
+// using.
+func (h *Host) showClockRate() {
+	if !h.rawMode || h.console != nil || h.screen != nil {
+		return
+	}
+	fmt.Fprintf(h.rawTerminal, "\r%sRunning at %.3f MHz%s\x1b[K", term.BrightYellow, h.clock.rate(), term.Reset)
+	h.statusLine = true
+}
+
+// Erase the console's status line if it is showing.
+func (h *Host) clearStatusLine() {
+	if h.statusLine {
+		h.statusLine = false
+		fmt.Fprint(h.rawTerminal, "\r\x1b[K")
+	}
+}
@@ -29,8 +29,17 @@ func init() {
 		Brief: "Assemble a file from disk and save the binary to disk",
 		Description: "Run the cross-assembler on the specified file," +
 			" producing a binary file and source map file if successful." +
-			" If you want verbose output, specify true as a second parameter.",
-		Usage: "assemble file <filename> [<verbose>]",
+			" If you want verbose output, specify true as a second parameter." +
+			" If the AsmListing setting is enabled, a listing file is also" +
+			" produced. If the AsmZPReport setting is enabled, instructions" +
+			" whose operands were shortened to zero-page addressing are" +
+			" listed. If the AsmRelax setting is enabled, branches whose" +
+			" targets are out of range are expanded into an inverted branch" +
+			" and a JMP. By default the machine code is saved as a raw" +
+			" binary file; specify a format of hex (Intel HEX), s19 or s28" +
+			" (Motorola S-record), or prg (Commodore program file) to save" +
+			" it in another format.",
+		Usage: "assemble file <filename> [<verbose>] [<format>]",
 		Data:  (*Host).cmdAssembleFile,
 	})
 	as.AddCommand(cmd.CommandDescriptor{
@@ -94,6 +103,20 @@ func init() {
 		Data:  (*Host).cmdBreakpointDisable,
 	})
 
+	// CPU commands
+	cp := root.AddSubtree(cmd.TreeDescriptor{Name: "cpu", Brief: "CPU commands"})
+	cp.AddCommand(cmd.CommandDescriptor{
+		Name:  "arch",
+		Brief: "Select the CPU architecture",
+		Description: "Select the architecture of the emulated CPU: '6502' (or" +
+			" 'nmos') or '65c02' (or 'cmos'). The instruction set is rebuilt," +
+			" while registers and memory are preserved. The interactive" +
+			" assembler and the disassembler follow the selected architecture." +
+			" Without an argument, display the current architecture.",
+		Usage: "cpu arch [<architecture>]",
+		Data:  (*Host).cmdCPUArch,
+	})
+
 	// Data breakpoint commands
 	db := root.AddSubtree(cmd.TreeDescriptor{Name: "databreakpoint", Brief: "Data Breakpoint commands"})
 	db.AddCommand(cmd.CommandDescriptor{
@@ -138,6 +161,46 @@ func init() {
 		Data:        (*Host).cmdDataBreakpointDisable,
 	})
 
+	// Device commands
+	dv := root.AddSubtree(cmd.TreeDescriptor{Name: "device", Brief: "Device commands"})
+	dv.AddCommand(cmd.CommandDescriptor{
+		Name:  "list",
+		Brief: "List devices",
+		Description: "List all devices mapped into the address space, along" +
+			" with the types of device that may be added.",
+		Usage: "device list",
+		Data:  (*Host).cmdDeviceList,
+	})
+	dv.AddCommand(cmd.CommandDescriptor{
+		Name:  "add",
+		Brief: "Add a device",
+		Description: "Add a device of the requested type and map its" +
+			" registers into the address space starting at the specified" +
+			" address. Reads and writes within the mapped range go to the" +
+			" device instead of memory, and the device's interrupt output" +
+			" drives the CPU's IRQ line. The device is named after its type" +
+			" unless a name is given. The serial side of an 'acia' device" +
+			" may be connected to 'console' (the terminal, while the CPU is" +
+			" running), 'pipe:<path>' (named pipes <path>.in and" +
+			" <path>.out), 'pty' (a new pseudo-terminal) or" +
+			" 'tcp:<port>' (a localhost TCP listener). A 'block' device" +
+			" may be given a disk image file to attach, followed by 'ro'" +
+			" to write protect it. A 'display' device is 40x25 characters" +
+			" unless another size such as 80x24 is given, and is drawn on" +
+			" the terminal while the CPU runs if 'console' is given. A" +
+			" 'framebuffer' device is 256x240 pixels at 4 bits per pixel" +
+			" unless another size or depth such as 128x128 or 8bpp is given.",
+		Usage: "device add <type> <address> [<connection>] [name=<name>]",
+		Data:  (*Host).cmdDeviceAdd,
+	})
+	dv.AddCommand(cmd.CommandDescriptor{
+		Name:        "remove",
+		Brief:       "Remove a device",
+		Description: "Remove a previously added device from the address space.",
+		Usage:       "device remove <name>",
+		Data:        (*Host).cmdDeviceRemove,
+	})
+
 	root.AddCommand(cmd.CommandDescriptor{
 		Name:  "disassemble",
 		Brief: "Disassemble code",
@@ -148,6 +211,27 @@ func init() {
 		Usage: "disassemble [<address>] [<lines>]",
 		Data:  (*Host).cmdDisassemble,
 	})
+
+	// Drive commands
+	dr := root.AddSubtree(cmd.TreeDescriptor{Name: "drive", Brief: "Disk drive commands"})
+	dr.AddCommand(cmd.CommandDescriptor{
+		Name:  "attach",
+		Brief: "Attach a disk image",
+		Description: "Attach a disk image file to a block device, replacing" +
+			" any image already attached. The image is read and written in" +
+			" 512-byte sectors, and any partial sector at its end is" +
+			" ignored. Add 'ro' to write protect the image.",
+		Usage: "drive attach <device> <filename> [ro]",
+		Data:  (*Host).cmdDriveAttach,
+	})
+	dr.AddCommand(cmd.CommandDescriptor{
+		Name:        "detach",
+		Brief:       "Detach a disk image",
+		Description: "Close the disk image attached to a block device.",
+		Usage:       "drive detach <device>",
+		Data:        (*Host).cmdDriveDetach,
+	})
+
 	root.AddCommand(cmd.CommandDescriptor{
 		Name:        "evaluate",
 		Brief:       "Evaluate an expression",
@@ -186,11 +270,32 @@ func init() {
 		Brief: "Load a binary file",
 		Description: "Load the contents of a binary file into the emulated" +
 			" system's memory. If the file has an associated source map, it" +
-			" will be loaded too. If the file contains raw binary data, you must" +
+			" will be loaded too. Intel HEX and Motorola S-record files are" +
+			" detected automatically, and each region they contain is loaded" +
+			" at its recorded address after its record checksums are" +
+			" validated. Files with a .prg extension are loaded at the" +
+			" address stored in their first two bytes unless another address" +
+			" is specified. If the file contains raw binary data, you must" +
 			" specify the address where the data will be loaded.",
 		Usage: "load <filename> [<address>]",
 		Data:  (*Host).cmdLoad,
 	})
+	root.AddCommand(cmd.CommandDescriptor{
+		Name:  "machine",
+		Brief: "Set up a complete machine",
+		Description: "Set up the emulated system as the named machine," +
+			" replacing all devices, loading its ROM and resetting the CPU" +
+			" through the reset vector. Without a machine name, list the" +
+			" available machines. The 'apple1' machine loads a 256-byte" +
+			" WozMon ROM image at $FF00 and connects the keyboard and display" +
+			" on the PIA at $D010-$D013 to the console. Any other name is" +
+			" the filename of a JSON or TOML machine configuration" +
+			" describing the CPU architecture, clock rate, RAM and ROM" +
+			" regions with their image files, devices with their addresses" +
+			" and interrupt wiring, and a startup script.",
+		Usage: "machine [<name> <args>...]",
+		Data:  (*Host).cmdMachine,
+	})
 
 	// Memory commands
 	me := root.AddSubtree(cmd.TreeDescriptor{Name: "memory", Brief: "Memory commands"})
@@ -223,6 +328,30 @@ func init() {
 		Usage: "memory copy <dst addr> <src addr begin> <src addr end>",
 		Data:  (*Host).cmdMemoryCopy,
 	})
+	me.AddCommand(cmd.CommandDescriptor{
+		Name:  "export",
+		Brief: "Export memory as source code",
+		Description: "Disassemble a range of memory into a source file that" +
+			" reassembles to identical machine code. You must specify the" +
+			" file name, the first byte of the range, and the last byte of" +
+			" the range. Code is found by tracing execution from the reset," +
+			" IRQ and NMI vectors and from any listed entry point addresses;" +
+			" everything else is written as data.",
+		Usage: "memory export <filename> <addr begin> <addr end> [<entry> ...]",
+		Data:  (*Host).cmdMemoryExport,
+	})
+	me.AddCommand(cmd.CommandDescriptor{
+		Name:  "save",
+		Brief: "Save memory to a file",
+		Description: "Save a range of memory to a file. You must specify the" +
+			" file name, the first byte of the range, and the last byte of the" +
+			" range. The optional format may be bin (raw binary), hex (Intel" +
+			" HEX), s19 or s28 (Motorola S-record), or prg (Commodore program" +
+			" file). If no format is given, it is chosen from the file's" +
+			" extension, defaulting to raw binary.",
+		Usage: "memory save <filename> <addr begin> <addr end> [<format>]",
+		Data:  (*Host).cmdMemorySave,
+	})
 
 	root.AddCommand(cmd.CommandDescriptor{
 		Name:        "quit",
@@ -247,10 +376,54 @@ func init() {
 		Name:  "run",
 		Brief: "Run the CPU",
 		Description: "Run the CPU until a breakpoint is hit or until the" +
-			" user types Ctrl-C.",
+			" user types Ctrl-C. Execution is paced at the clock rate in" +
+			" the ClockHz variable unless it is 0 or Turbo is set.",
 		Usage: "run",
 		Data:  (*Host).cmdRun,
 	})
+
+	// Screen commands
+	sc := root.AddSubtree(cmd.TreeDescriptor{Name: "screen", Brief: "Screen commands"})
+	sc.AddCommand(cmd.CommandDescriptor{
+		Name:  "capture",
+		Brief: "Save a framebuffer image",
+		Description: "Save the image shown by a framebuffer device to a PNG" +
+			" or GIF file, chosen by the file's extension. If no device is" +
+			" named, the first framebuffer is captured.",
+		Usage: "screen capture <filename> [<device>]",
+		Data:  (*Host).cmdScreenCapture,
+	})
+	sc.AddCommand(cmd.CommandDescriptor{
+		Name:  "record",
+		Brief: "Record framebuffer frames to an animated GIF",
+		Description: "Start recording the frames completed by a framebuffer" +
+			" device to an animated GIF file, which is saved when recording" +
+			" stops. If a frame interval n is given, only every nth frame" +
+			" is recorded. If no device is named, the first framebuffer is" +
+			" recorded. Frames are played back at the rate they were" +
+			" completed, assuming 60 frames per second, except that no" +
+			" frame is shown for less than 2/100 second, so recording every" +
+			" frame plays back at 50 frames per second.",
+		Usage: "screen record <filename> [<n> [<device>]]",
+		Data:  (*Host).cmdScreenRecord,
+	})
+	sc.AddCommand(cmd.CommandDescriptor{
+		Name:  "show",
+		Brief: "Show a text display",
+		Description: "Show the screen of a text display device as text. If" +
+			" no device is named, the display shown on the console, or" +
+			" else the first text display, is shown.",
+		Usage: "screen show [<device>]",
+		Data:  (*Host).cmdScreenShow,
+	})
+	sc.AddCommand(cmd.CommandDescriptor{
+		Name:        "stop",
+		Brief:       "Stop recording",
+		Description: "Stop recording framebuffer frames and save the animated GIF file.",
+		Usage:       "screen stop",
+		Data:        (*Host).cmdScreenStop,
+	})
+
 	root.AddCommand(cmd.CommandDescriptor{
 		Name:  "set",
 		Brief: "Set a configuration variable",
@@ -291,6 +464,39 @@ func init() {
 		Data:  (*Host).cmdStepOut,
 	})
 
+	// Symbol commands
+	sy := root.AddSubtree(cmd.TreeDescriptor{Name: "symbols", Brief: "Symbol commands"})
+	sy.AddCommand(cmd.CommandDescriptor{
+		Name:  "load",
+		Brief: "Load symbols from a file",
+		Description: "Load address labels and constants from a symbol file" +
+			" produced by another tool, making them usable in expressions and" +
+			" breakpoints. VICE label files, ld65 debug info (.dbg) files and" +
+			" plain lists of 'name = $addr' assignments are recognized" +
+			" automatically. Symbols with the same name as previously loaded" +
+			" symbols replace them. The disassembler names addresses with" +
+			" labels, and with other symbols outside the zero page. Plain" +
+			" lists don't distinguish labels from constants, so add 'labels'" +
+			" when every name in the file is an address, as in a list of a" +
+			" ROM's entry points and variables.",
+		Usage: "symbols load <filename> [labels]",
+		Data:  (*Host).cmdSymbolsLoad,
+	})
+	sy.AddCommand(cmd.CommandDescriptor{
+		Name:        "list",
+		Brief:       "List loaded symbols",
+		Description: "Display all symbols loaded from symbol files.",
+		Usage:       "symbols list",
+		Data:        (*Host).cmdSymbolsList,
+	})
+	sy.AddCommand(cmd.CommandDescriptor{
+		Name:        "clear",
+		Brief:       "Clear loaded symbols",
+		Description: "Remove all symbols loaded from symbol files.",
+		Usage:       "symbols clear",
+		Data:        (*Host).cmdSymbolsClear,
+	})
+
 	// Add command shortcuts.
 	root.AddShortcut("a", "assemble file")
 	root.AddShortcut("ai", "assemble interactive")
@@ -302,6 +508,7 @@ func init() {
 	root.AddShortcut("bl", "breakpoint list")
 	root.AddShortcut("be", "breakpoint enable")
 	root.AddShortcut("bd", "breakpoint disable")
+	root.AddShortcut("ca", "cpu arch")
 	root.AddShortcut("d", "disassemble")
 	root.AddShortcut("db", "databreakpoint")
 	root.AddShortcut("dbp", "databreakpoint")
@@ -310,6 +517,12 @@ func init() {
 	root.AddShortcut("dbr", "databreakpoint remove")
 	root.AddShortcut("dbe", "databreakpoint enable")
 	root.AddShortcut("dbd", "databreakpoint disable")
+	root.AddShortcut("dv", "device")
+	root.AddShortcut("dvl", "device list")
+	root.AddShortcut("dva", "device add")
+	root.AddShortcut("dvr", "device remove")
+	root.AddShortcut("dra", "drive attach")
+	root.AddShortcut("drd", "drive detach")
 	root.AddShortcut("e", "evaluate")
 	root.AddShortcut("l", "list")
 	root.AddShortcut("m", "memory dump")
@@ -319,6 +532,9 @@ func init() {
 	root.AddShortcut("s", "step over")
 	root.AddShortcut("si", "step in")
 	root.AddShortcut("so", "step out")
+	root.AddShortcut("sl", "symbols load")
+	root.AddShortcut("sc", "screen capture")
+	root.AddShortcut("ss", "screen show")
 	root.AddShortcut("?", "help")
 	root.AddShortcut(".", "register")
 
@@ -0,0 +1,332 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cjr29/go6502/cpu"
+	"github.com/cjr29/go6502/devices"
+)
+
+// A machineConfig describes an emulated system. It is read from a JSON
+// machine configuration file such as:
+//
+//	{
+//	    "name": "rev-b",
+//	    "cpu": "65c02",
+//	    "clockhz": 2000000,
+//	    "memory": [
+//	        {"type": "ram", "start": "$0000", "end": "$7FFF"},
+//	        {"type": "rom", "start": "$E000", "end": "$FFFF", "image": "monitor.bin"}
+//	    ],
+//	    "devices": [
+//	        {"type": "via", "address": "$6000", "irq": "irq"},
+//	        {"type": "acia", "address": "$8000", "connection": "tcp:6551"},
+//	        {"type": "block", "address": "$8100", "image": "fat16.img"}
+//	    ],
+//	    "script": "startup.cmd"
+//	}
+//
+// A file with the .toml extension holds the same configuration in TOML,
+// with the memory regions and devices as [[memory]] and [[devices]]
+// tables. File names are relative to the directory containing the
+// configuration.
+type machineConfig struct {
+	Name    string         `json:"name"`
+	CPU     string         `json:"cpu"`
+	ClockHz float64        `json:"clockhz"`
+	Memory  []memoryConfig `json:"memory"`
+	Devices []deviceConfig `json:"devices"`
+	Script  string         `json:"script"`
+}
+
+// A memoryConfig describes a region of RAM or ROM. A RAM region is cleared
+// and then loaded with its image, if any. A ROM region holds its image,
+// padded with $FF to the end of the region, and ignores writes. A ROM
+// without an end address is as large as its image.
+type memoryConfig struct {
+	Type  string   `json:"type"`
+	Start address  `json:"start"`
+	End   *address `json:"end"`
+	Image string   `json:"image"`
+}
+
+// A deviceConfig describes a device mapped into the address space. The
+// interrupt output drives "irq" (the default), "nmi" or "none". The
+// connection, if any, is interpreted as it is by "device add". A block
+// device's disk image is write protected if it is read-only.
+type deviceConfig struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Address    address `json:"address"`
+	IRQ        string  `json:"irq"`
+	Connection string  `json:"connection"`
+	Image      string  `json:"image"`
+	ReadOnly   bool    `json:"readonly"`
+}
+
+// An address is a 16-bit address in a machine configuration. It may be a
+// number or a string holding a decimal, $hex or 0xhex number.
+type address uint16
+
+func (a *address) UnmarshalJSON(b []byte) error {
+	var v int64
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		var perr error
+		switch {
+		case strings.HasPrefix(s, "$"):
+			v, perr = strconv.ParseInt(s[1:], 16, 32)
+		case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+			v, perr = strconv.ParseInt(s[2:], 16, 32)
+		default:
+			v, perr = strconv.ParseInt(s, 10, 32)
+		}
+		if perr != nil {
+			return fmt.Errorf("invalid address %s", b)
+		}
+	} else if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("invalid address %s", b)
+	}
+	if v < 0 || v > 0xffff {
+		return fmt.Errorf("address %s out of range", b)
+	}
+	*a = address(v)
+	return nil
+}
+
+// Parse a CPU architecture name.
+func parseArch(s string) (cpu.Architecture, error) {
+	switch strings.ToLower(s) {
+	case "6502", "nmos":
+		return cpu.NMOS, nil
+	case "65c02", "cmos":
+		return cpu.CMOS, nil
+	default:
+		return cpu.NMOS, fmt.Errorf("unknown CPU architecture '%s'", s)
+	}
+}
+
+// Return a description of a CPU architecture.
+func archName(arch cpu.Architecture) string {
+	if arch == cpu.CMOS {
+		return "65C02 (CMOS)"
+	}
+	return "6502 (NMOS)"
+}
+
+// SetArch selects the CPU architecture by name ("6502" or "nmos", "65c02"
+// or "cmos"). The CPU's instruction set is rebuilt, while its registers
+// and memory are preserved.
+func (h *Host) SetArch(name string) error {
+	arch, err := parseArch(name)
+	if err != nil {
+		return err
+	}
+	h.setArch(arch)
+	return nil
+}
+
+// Set the CPU's architecture, keeping its registers and memory.
+func (h *Host) setArch(arch cpu.Architecture) {
+	h.cpu.Arch = arch
+	h.cpu.InstSet = cpu.GetInstructionSet(arch)
+}
+
+// Set up the machine described by a JSON or TOML machine configuration
+// file. The current machine is replaced only if the new one is set up
+// successfully.
+func (h *Host) loadMachineConfig(filename string) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yaml" || ext == ".yml" {
+		return fmt.Errorf("machine config '%s': YAML isn't supported (use JSON or TOML)", filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if ext == ".toml" {
+		if data, err = tomlToJSON(data); err != nil {
+			return fmt.Errorf("machine config '%s': %v", filename, err)
+		}
+	}
+
+	var mc machineConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&mc); err != nil {
+		return fmt.Errorf("machine config '%s': %v", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	path := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	// Check everything that can be checked before the current machine is
+	// torn down.
+	arch := h.cpu.Arch
+	if mc.CPU != "" {
+		if arch, err = parseArch(mc.CPU); err != nil {
+			return err
+		}
+	}
+	if mc.ClockHz < 0 {
+		return fmt.Errorf("invalid clock rate %v", mc.ClockHz)
+	}
+	images := make([][]byte, len(mc.Memory))
+	for i, r := range mc.Memory {
+		if r.End != nil && *r.End < r.Start {
+			return fmt.Errorf("memory region at $%04X ends before it starts", r.Start)
+		}
+		if r.Image != "" {
+			if images[i], err = ioutil.ReadFile(path(r.Image)); err != nil {
+				return err
+			}
+		}
+		size := len(images[i])
+		if r.End != nil {
+			size = int(*r.End) - int(r.Start) + 1
+		}
+		switch strings.ToLower(r.Type) {
+		case "ram":
+			if r.End == nil {
+				return fmt.Errorf("RAM region at $%04X has no end address", r.Start)
+			}
+		case "rom":
+			if size == 0 {
+				return fmt.Errorf("ROM region at $%04X has no image or end address", r.Start)
+			}
+		default:
+			return fmt.Errorf("unknown memory type '%s'", r.Type)
+		}
+		if len(images[i]) > size || int(r.Start)+size > 0x10000 {
+			return fmt.Errorf("image '%s' does not fit at $%04X", r.Image, r.Start)
+		}
+	}
+	for _, d := range mc.Devices {
+		if _, ok := deviceTypes[strings.ToLower(d.Type)]; !ok {
+			return fmt.Errorf("unknown device type '%s'", d.Type)
+		}
+		if _, err := parseLine(d.IRQ); err != nil {
+			return err
+		}
+		if d.Image != "" && strings.ToLower(d.Type) != "block" {
+			return fmt.Errorf("device type '%s' has no disk image", d.Type)
+		}
+	}
+
+	// Build the new machine's ROMs and devices on a bus of their own, so
+	// that the current machine is kept if any of them can't be created,
+	// connected or mapped. Connections are opened while the current
+	// machine still holds its own.
+	clockHz := h.settings.ClockHz
+	if mc.ClockHz > 0 {
+		h.settings.ClockHz = int(mc.ClockHz)
+	}
+	staged := &deviceSet{bus: devices.NewBus(h.mem), types: make(map[string]string)}
+	h.swapDevices(staged)
+	if err := h.addConfigDevices(&mc, images, path); err != nil {
+		h.removeDevices()
+		h.swapDevices(staged)
+		h.settings.ClockHz = clockHz
+		return err
+	}
+	h.swapDevices(staged)
+
+	h.removeDevices()
+	h.installDevices(staged)
+	h.setArch(arch)
+	for i, r := range mc.Memory {
+		if strings.ToLower(r.Type) == "ram" {
+			buf := make([]byte, int(*r.End)-int(r.Start)+1)
+			copy(buf, images[i])
+			h.mem.StoreBytes(uint16(r.Start), buf)
+		}
+	}
+
+	h.machineName = mc.Name
+	if h.machineName == "" {
+		h.machineName = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+
+	h.resetDevices()
+	h.cpu.Reset()
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+
+	if mc.Script != "" {
+		return h.executeFile(path(mc.Script))
+	}
+	return nil
+}
+
+// Map the ROM regions and devices of a machine configuration.
+func (h *Host) addConfigDevices(mc *machineConfig, images [][]byte, path func(string) string) error {
+	for i, r := range mc.Memory {
+		if strings.ToLower(r.Type) != "rom" {
+			continue
+		}
+		size := len(images[i])
+		if r.End != nil {
+			size = int(*r.End) - int(r.Start) + 1
+		}
+		buf := bytes.Repeat([]byte{0xff}, size)
+		copy(buf, images[i])
+		name := h.deviceName("rom")
+		m, err := h.bus.Map(name, uint16(r.Start), 0, devices.NewROM(buf))
+		if err != nil {
+			return err
+		}
+		m.Line = devices.LineNone
+		h.deviceTypes[name] = "rom"
+	}
+
+	for _, d := range mc.Devices {
+		var args []string
+		if d.Connection != "" {
+			args = append(args, d.Connection)
+		}
+		if d.Image != "" {
+			args = append(args, path(d.Image))
+			if d.ReadOnly {
+				args = append(args, "ro")
+			}
+		}
+		m, err := h.addDevice(d.Type, d.Name, uint16(d.Address), args)
+		if err != nil {
+			return err
+		}
+		if d.IRQ != "" {
+			m.Line, _ = parseLine(d.IRQ)
+		}
+	}
+	return nil
+}
+
+// Parse the name of the interrupt line a device drives.
+func parseLine(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "irq":
+		return devices.LineIRQ, nil
+	case "nmi":
+		return devices.LineNMI, nil
+	case "none":
+		return devices.LineNone, nil
+	default:
+		return 0, errors.New("interrupt line must be 'irq', 'nmi' or 'none'")
+	}
+}
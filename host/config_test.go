@@ -0,0 +1,139 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cjr29/go6502/cpu"
+	"github.com/cjr29/go6502/devices"
+)
+
+func TestMachineConfigKeepsCurrentMachine(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+	old, err := h.addDevice("via", "", 0x6000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, c := range []struct{ name, config string }{
+		{"overlap.json", `{"devices": [
+			{"type": "via", "address": "$7000"},
+			{"type": "pia", "address": "$7008"}]}`},
+		{"rom.json", `{"memory": [{"type": "rom", "start": "$F000", "end": "$FFFF"}],
+			"devices": [{"type": "via", "address": "$FFF0"}]}`},
+		{"image.json", `{"devices": [
+			{"type": "block", "address": "$7000", "image": "missing.img"}]}`},
+		{"machine.yaml", `{}`},
+	} {
+		filename := filepath.Join(dir, c.name)
+		if err := os.WriteFile(filename, []byte(c.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.loadMachineConfig(filename); err == nil {
+			t.Errorf("%s: loaded without error", c.name)
+		}
+		if m := h.bus.Mappings(); len(m) != 1 || m[0] != old || h.deviceTypes["via"] != "via" {
+			t.Errorf("%s: current machine was not kept", c.name)
+		}
+	}
+
+	filename := filepath.Join(dir, "board.json")
+	config := `{"devices": [{"type": "pia", "address": "$7000", "irq": "nmi"}]}`
+	if err := os.WriteFile(filename, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.loadMachineConfig(filename); err != nil {
+		t.Fatal(err)
+	}
+	m := h.bus.Mappings()
+	if len(m) != 1 || m[0].Name != "pia" || m[0].Addr != 0x7000 || h.deviceTypes["via"] != "" {
+		t.Fatalf("unexpected devices %v", m)
+	}
+	if h.cpu.Mem.LoadByte(0x7001) != h.bus.Find("pia").Device.Peek(1) {
+		t.Error("the CPU doesn't see the new machine's devices")
+	}
+}
+
+func TestMachineConfigTOML(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+
+	dir := t.TempDir()
+	config := `# Rev B board
+name = "rev-b"
+cpu = '65c02'
+clockhz = 2_000_000
+
+[[memory]]
+type = "rom"
+start = 0xF000
+image = "monitor.bin"   # padded to the end of memory
+end = "$FFFF"
+
+[[devices]]
+type = "via"
+address = "$6000"
+irq = "nmi"
+
+[[devices]]
+type = "pia"
+name = "keys"
+address = 0x6010
+`
+	files := map[string]string{"board.toml": config, "monitor.bin": "\xea\x4c\x00\xf0"}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.loadMachineConfig(filepath.Join(dir, "board.toml")); err != nil {
+		t.Fatal(err)
+	}
+
+	m := h.bus.Mappings()
+	if len(m) != 3 || m[0].Addr != 0xf000 || m[1].Name != "via" || m[1].Line != devices.LineNMI ||
+		m[2].Name != "keys" || m[2].Addr != 0x6010 {
+		t.Errorf("unexpected devices %v", m)
+	}
+	if h.machineName != "rev-b" || h.settings.ClockHz != 2000000 || h.cpu.Arch != cpu.CMOS {
+		t.Errorf("unexpected machine '%s' at %d Hz", h.machineName, h.settings.ClockHz)
+	}
+	if h.bus.LoadByte(0xf001) != 0x4c || h.bus.LoadByte(0xfffc) != 0xff {
+		t.Error("ROM image not loaded")
+	}
+}
+
+func TestTOMLToJSON(t *testing.T) {
+	for _, c := range []struct{ toml, json string }{
+		{"a = 1\nb = -2.5\nc = true\n", `{"a":1,"b":-2.5,"c":true}`},
+		{"s = \"x\\ty\" # comment\nl = 'C:\\dir'", `{"l":"C:\\dir","s":"x\ty"}`},
+		{"n = [0x10, 0o17, 0b11,\n  1_000]\n", `{"n":[16,15,3,1000]}`},
+		{"m = [{t = \"ram\", e = 5}, {}]", `{"m":[{"e":5,"t":"ram"},{}]}`},
+		{"[t]\nx = 1\n[[a]]\n[[a]]\ny = 2", `{"a":[{},{"y":2}],"t":{"x":1}}`},
+	} {
+		b, err := tomlToJSON([]byte(c.toml))
+		if err != nil || string(b) != c.json {
+			t.Errorf("%q converted to %s (%v), expected %s", c.toml, b, err, c.json)
+		}
+	}
+
+	for _, c := range []struct{ toml, err string }{
+		{"a = 1\na = 2", "line 2: duplicate key 'a'"},
+		{"a = 1 b = 2", "line 1: unexpected 'b'"},
+		{"\n\na = \"open", "line 3: unterminated string"},
+		{"a = [1 2]", "line 1: missing ',' between array values"},
+		{"a = 1979-05-27", "line 1: invalid value '1979-05-27'"},
+		{"[t\n", "line 1: missing ']' after table name"},
+	} {
+		if _, err := tomlToJSON([]byte(c.toml)); err == nil || err.Error() != c.err {
+			t.Errorf("%q gave error %v, expected %s", c.toml, err, c.err)
+		}
+	}
+}
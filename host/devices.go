@@ -0,0 +1,368 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/cmd"
+	"github.com/cjr29/go6502/devices"
+	"github.com/cjr29/go6502/term"
+)
+
+// A deviceType describes a kind of device that may be added to the
+// emulated system with the "device add" command.
+type deviceType struct {
+	brief  string
+	create func(h *Host, name string, args []string) (devices.Device, error)
+}
+
+// All device types, indexed by the names used to add them.
+var deviceTypes = map[string]deviceType{
+	"acia": {
+		brief:  "6551 asynchronous communications interface adapter",
+		create: newACIA,
+	},
+	"block": {
+		brief:  "block storage controller for 512-byte sectors of a disk image",
+		create: newBlockDevice,
+	},
+	"display": {
+		brief:  "memory-mapped text display with a cursor",
+		create: newTextDisplay,
+	},
+	"framebuffer": {
+		brief:  "memory-mapped bitmap display of indexed pixels",
+		create: newFramebuffer,
+	},
+	"pia": {
+		brief: "6821 peripheral interface adapter",
+		create: func(h *Host, name string, args []string) (devices.Device, error) {
+			return devices.NewPIA(), nil
+		},
+	},
+	"via": {
+		brief: "W65C22 versatile interface adapter",
+		create: func(h *Host, name string, args []string) (devices.Device, error) {
+			return devices.NewVIA(), nil
+		},
+	},
+}
+
+// Create an ACIA whose serial side is connected to the host console or to
+// the stream selected by devices.OpenSerial.
+func newACIA(h *Host, name string, args []string) (devices.Device, error) {
+	a := devices.NewACIA()
+	a.ClockHz = h.clockRate()
+	if len(args) == 0 {
+		return a, nil
+	}
+
+	if strings.ToLower(args[0]) == "console" {
+		if h.console != nil {
+			return nil, fmt.Errorf("console is already connected to device '%s'", h.consoleDevice)
+		}
+		h.console = newConsolePort()
+		h.consoleDevice = name
+		a.Connect(h.console, "console")
+		return a, nil
+	}
+
+	port, desc, err := devices.OpenSerial(args[0])
+	if err != nil {
+		return nil, err
+	}
+	a.Connect(port, desc)
+	return a, nil
+}
+
+// Create a block device with the disk image, if any, attached. The image
+// is write protected if it is followed by "ro".
+func newBlockDevice(h *Host, name string, args []string) (devices.Device, error) {
+	b := devices.NewBlockDevice()
+	if len(args) == 0 {
+		return b, nil
+	}
+	readOnly := len(args) > 1 && strings.ToLower(args[1]) == "ro"
+	if err := b.Attach(args[0], readOnly); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// A consolePort connects a device's serial side to the host console.
+// Output is written directly to stdout, and input typed while the CPU is
+// running is delivered by pollConsole.
+type consolePort struct {
+	*io.PipeReader
+	w   *io.PipeWriter
+	out io.Writer
+}
+
+func newConsolePort() *consolePort {
+	r, w := io.Pipe()
+	return &consolePort{PipeReader: r, w: w, out: os.Stdout}
+}
+
+func (p *consolePort) Write(b []byte) (int, error) {
+	return p.out.Write(b)
+}
+
+func (p *consolePort) Close() error {
+	p.w.Close()
+	return p.PipeReader.Close()
+}
+
+// Deliver any input waiting on the console to the device connected to it.
+func (h *Host) pollConsole() {
+	fd := int(os.Stdin.Fd())
+	if h.console == nil || !term.InputReady(fd) {
+		return
+	}
+	var buf [64]byte
+	n, _ := os.Stdin.Read(buf[:])
+	if n > 0 {
+		h.console.w.Write(buf[:n])
+	}
+}
+
+// Return a sorted list of all device type names.
+func deviceTypeNames() []string {
+	names := make([]string, 0, len(deviceTypes))
+	for n := range deviceTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add a device of the named type to the emulated system, mapping it at the
+// address. Any additional arguments are passed to the device's constructor.
+func (h *Host) addDevice(typ, name string, addr uint16, args []string) (*devices.Mapping, error) {
+	t, ok := deviceTypes[strings.ToLower(typ)]
+	if !ok {
+		return nil, fmt.Errorf("unknown device type '%s' (known types: %s)",
+			typ, strings.Join(deviceTypeNames(), ", "))
+	}
+
+	if name == "" {
+		name = h.deviceName(typ)
+	}
+
+	d, err := t.create(h, name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := h.bus.Map(name, addr, 0, d)
+	if err != nil {
+		h.closeDevice(name, d)
+		return nil, err
+	}
+	if _, ok := d.(devices.Interrupter); !ok {
+		m.Line = devices.LineNone
+	}
+	h.deviceTypes[name] = strings.ToLower(typ)
+	return m, nil
+}
+
+// Return a description of what a mapped device's external side is
+// connected to, if anything.
+func (h *Host) connection(m *devices.Mapping) string {
+	if m.Name == h.screenDevice {
+		return "console"
+	}
+	if h.recording != nil && m.Name == h.recording.device {
+		return "recording " + h.recording.path
+	}
+	if c, ok := m.Device.(interface{ Connection() string }); ok {
+		return c.Connection()
+	}
+	return ""
+}
+
+// Release any connections held by a device that is being discarded.
+func (h *Host) closeDevice(name string, d devices.Device) {
+	if c, ok := d.(io.Closer); ok {
+		c.Close()
+	}
+	if h.consoleDevice == name {
+		h.console, h.consoleDevice = nil, ""
+	}
+	if h.screenDevice == name {
+		h.screen, h.screenDevice, h.screenShown = nil, "", false
+	}
+	if h.recording != nil && h.recording.device == name {
+		h.stopRecording()
+	}
+}
+
+// Return an unused device name based on the device type.
+func (h *Host) deviceName(typ string) string {
+	name := strings.ToLower(typ)
+	for i := 2; h.bus.Find(name) != nil; i++ {
+		name = strings.ToLower(typ) + strconv.Itoa(i)
+	}
+	return name
+}
+
+// Reset all devices that support being reset.
+func (h *Host) resetDevices() {
+	for _, m := range h.bus.Mappings() {
+		if r, ok := m.Device.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+	}
+}
+
+func (h *Host) cmdDeviceAdd(c *cmd.Command, args []string) error {
+	if len(args) < 2 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	addr, err := h.parseExpr(args[1])
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	var name string
+	var rest []string
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "name=") {
+			name = arg[5:]
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	m, err := h.addDevice(args[0], name, addr, rest)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Added %s '%s' at $%04X..$%04X", h.deviceTypes[m.Name],
+		m.Name, m.Addr, int(m.Addr)+m.Size-1)
+	if conn := h.connection(m); conn != "" {
+		fmt.Fprintf(h, " connected to %s", conn)
+	}
+	fmt.Fprintln(h, ".")
+	return nil
+}
+
+func (h *Host) cmdDeviceRemove(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	m := h.bus.Find(args[0])
+	if m == nil {
+		fmt.Fprintf(h, "device '%s' not found\n", args[0])
+		return nil
+	}
+
+	h.bus.Unmap(m.Name)
+	h.closeDevice(m.Name, m.Device)
+	delete(h.deviceTypes, args[0])
+	fmt.Fprintf(h, "Removed device '%s'.\n", args[0])
+	return nil
+}
+
+func (h *Host) cmdDeviceList(c *cmd.Command, args []string) error {
+	mappings := h.bus.Mappings()
+	if len(mappings) == 0 {
+		fmt.Fprintln(h, "No devices added.")
+	} else {
+		fmt.Fprintln(h, "Devices:")
+		for _, m := range mappings {
+			line := "IRQ"
+			switch m.Line {
+			case devices.LineNMI:
+				line = "NMI"
+			case devices.LineNone:
+				line = "-"
+			}
+			s := fmt.Sprintf("   %-12s %-11s $%04X..$%04X  %-3s  %s", m.Name,
+				h.deviceTypes[m.Name], m.Addr, int(m.Addr)+m.Size-1, line,
+				h.connection(m))
+			fmt.Fprintln(h, strings.TrimRight(s, " "))
+		}
+	}
+
+	fmt.Fprintln(h, "Device types:")
+	for _, n := range deviceTypeNames() {
+		fmt.Fprintf(h, "   %-12s %s\n", n, deviceTypes[n].brief)
+	}
+	return nil
+}
+
+// Return the named block device.
+func (h *Host) blockDevice(name string) (*devices.BlockDevice, error) {
+	m := h.bus.Find(name)
+	if m == nil {
+		return nil, fmt.Errorf("device '%s' not found", name)
+	}
+	b, ok := m.Device.(*devices.BlockDevice)
+	if !ok {
+		return nil, fmt.Errorf("device '%s' is not a block device", name)
+	}
+	return b, nil
+}
+
+func (h *Host) cmdDriveAttach(c *cmd.Command, args []string) error {
+	if len(args) < 2 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	b, err := h.blockDevice(args[0])
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	readOnly := len(args) > 2 && strings.ToLower(args[2]) == "ro"
+	if err := b.Attach(args[1], readOnly); err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Attached %s to '%s'.\n", b.Connection(), args[0])
+	return nil
+}
+
+func (h *Host) cmdDriveDetach(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	b, err := h.blockDevice(args[0])
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	image := b.Image()
+	if image == "" {
+		fmt.Fprintf(h, "No disk image attached to '%s'.\n", args[0])
+		return nil
+	}
+	if err := b.Detach(); err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Detached image %s from '%s'.\n", image, args[0])
+	return nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRunning is returned when the CPU is asked to run or step while it is
+// already running.
+var ErrRunning = errors.New("CPU is already running")
+
+// The executor runs this many instructions at a time while holding the
+// host lock, between which it checks for a pause and paces execution.
+const execBatch = 128
+
+// A StopReason explains why the CPU stopped running.
+type StopReason byte
+
+const (
+	StopPaused     StopReason = iota // paused by Pause or ctrl-C
+	StopCanceled                     // the run's context was canceled
+	StopTarget                       // reached the RunUntil address
+	StopBreakpoint                   // hit a code or data breakpoint
+	StopBRK                          // encountered a BRK instruction
+	StopStep                         // completed a single step
+)
+
+var stopReasons = []string{
+	"paused", "canceled", "reached target", "breakpoint", "BRK", "stepped",
+}
+
+func (r StopReason) String() string {
+	return stopReasons[r]
+}
+
+// An Event reports that the CPU started or stopped running.
+type Event struct {
+	Running bool       // true if the CPU started running
+	Reason  StopReason // why the CPU stopped, if it isn't running
+	PC      uint16     // program counter at the time of the event
+	Cycles  uint64     // CPU cycle count at the time of the event
+}
+
+// An executor runs the CPU on a background goroutine.
+type executor struct {
+	mu      sync.Mutex    // guards the CPU, memory and devices
+	running atomic.Bool   // the executor goroutine is running
+	pause   atomic.Bool   // the executor should stop at the next batch
+	done    chan struct{} // closed when the executor goroutine exits
+	reason  StopReason    // why the last run stopped
+	events  chan Event
+	last    atomic.Pointer[Event] // the most recent event
+}
+
+// Lock acquires exclusive access to the CPU, memory and devices. While the
+// CPU runs in the background, it is only suspended between instructions,
+// so registers and memory may be inspected and changed safely while the
+// lock is held. Lock must be held only briefly, since the CPU can't run
+// while it is held.
+func (h *Host) Lock() {
+	h.exec.mu.Lock()
+}
+
+// Unlock releases the lock acquired by Lock.
+func (h *Host) Unlock() {
+	h.exec.mu.Unlock()
+}
+
+// Events returns a channel that reports whenever the CPU starts or stops
+// running, including single steps. If the channel's buffer is full, the
+// oldest events are discarded to make room, so the last event received
+// always reflects the CPU's current state.
+func (h *Host) Events() <-chan Event {
+	return h.exec.events
+}
+
+// LastEvent returns the most recent event, which reports whether the CPU
+// is running and, if not, why it last stopped. Before the CPU first runs,
+// LastEvent returns a zero Event.
+func (h *Host) LastEvent() Event {
+	if e := h.exec.last.Load(); e != nil {
+		return *e
+	}
+	return Event{}
+}
+
+// Running returns true while the CPU is running in the background.
+func (h *Host) Running() bool {
+	return h.exec.running.Load()
+}
+
+// Start runs the CPU in the background until it is paused, hits a
+// breakpoint or BRK instruction, or the context is canceled.
+func (h *Host) Start(ctx context.Context) error {
+	h.Lock()
+	defer h.Unlock()
+	return h.start(ctx, -1)
+}
+
+// RunUntil runs the CPU in the background like Start, additionally
+// stopping when the program counter reaches the address.
+func (h *Host) RunUntil(ctx context.Context, addr uint16) error {
+	h.Lock()
+	defer h.Unlock()
+	return h.start(ctx, int(addr))
+}
+
+// Pause stops the CPU running in the background, returning once it has
+// stopped. It must not be called while holding the host lock.
+func (h *Host) Pause() {
+	h.Lock()
+	running, done := h.Running(), h.exec.done
+	h.Unlock()
+	if running {
+		h.exec.pause.Store(true)
+		<-done
+	}
+}
+
+// Step executes a single instruction while the CPU isn't running.
+func (h *Host) Step() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.Running() {
+		return ErrRunning
+	}
+
+	h.setState(stateRunning)
+	h.step()
+	h.setState(stateProcessingCommands)
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	h.notify(Event{Reason: StopStep, PC: h.cpu.Reg.PC, Cycles: h.cpu.Cycles})
+	return nil
+}
+
+// Start the executor goroutine, stopping at the target address unless it
+// is negative. The host lock must be held.
+func (h *Host) start(ctx context.Context, target int) error {
+	if h.Running() {
+		return ErrRunning
+	}
+
+	h.exec.running.Store(true)
+	h.exec.pause.Store(false)
+	h.exec.done = make(chan struct{})
+	h.setState(stateRunning)
+	h.clock.reset(h.cpu.Cycles)
+	h.notify(Event{Running: true, PC: h.cpu.Reg.PC, Cycles: h.cpu.Cycles})
+
+	go h.execute(ctx, target, h.exec.done)
+	return nil
+}
+
+// Run the CPU in batches until something stops it.
+func (h *Host) execute(ctx context.Context, target int, done chan struct{}) {
+	defer close(done)
+
+	reason := StopPaused
+	for running := true; running; {
+		h.Lock()
+		for i := 0; i < execBatch && h.state == stateRunning; i++ {
+			h.step()
+			if int(h.cpu.Reg.PC) == target {
+				reason, running = StopTarget, false
+				break
+			}
+		}
+		switch h.state {
+		case stateBreakpoint:
+			reason, running = StopBreakpoint, false
+		case stateInterrupted:
+			reason, running = StopBRK, false
+		}
+		h.pollConsole()
+		h.updateScreen(false)
+		delay := h.clock.delay(h.cpu.Cycles, h.pacingRate())
+		if h.clock.measure(h.cpu.Cycles) {
+			h.showClockRate()
+		}
+		h.Unlock()
+
+		switch {
+		case !running:
+		case h.exec.pause.Load():
+			reason, running = StopPaused, false
+		case ctx.Err() != nil:
+			reason, running = StopCanceled, false
+		case delay > 0:
+			time.Sleep(delay)
+		}
+	}
+
+	h.Lock()
+	h.clearStatusLine()
+	h.releaseScreen()
+	if n, d := h.clock.stop(h.cpu.Cycles); d >= measureInterval {
+		fmt.Fprintf(h, "Ran %d cycles in %.2fs (%.3f MHz).\n", n, d.Seconds(), float64(n)/d.Seconds()/1e6)
+	}
+	h.setState(stateProcessingCommands)
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	h.exec.reason = reason
+	h.exec.running.Store(false)
+	h.notify(Event{Reason: reason, PC: h.cpu.Reg.PC, Cycles: h.cpu.Cycles})
+	h.Unlock()
+}
+
+// Wait for the CPU running in the background to stop, calling the idle
+// function periodically while it runs.
+func (h *Host) wait(idle func()) StopReason {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.exec.done:
+			return h.exec.reason
+		case <-ticker.C:
+			idle()
+		}
+	}
+}
+
+// Report an event without waiting for it to be received. If the buffer is
+// full, the oldest events are discarded until the new one fits.
+func (h *Host) notify(e Event) {
+	h.exec.last.Store(&e)
+	for {
+		select {
+		case h.exec.events <- e:
+			return
+		default:
+		}
+		select {
+		case <-h.exec.events:
+		default:
+		}
+	}
+}
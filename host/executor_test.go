@@ -0,0 +1,126 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Create a host whose CPU is about to run a loop of NOPs at $1000.
+func newLoopHost() *Host {
+	h := New()
+	h.mem.StoreBytes(0x1000, []byte{0xea, 0xea, 0xea, 0x4c, 0x00, 0x10})
+	h.cpu.Reg.PC = 0x1000
+	return h
+}
+
+// Receive the next event, failing if none arrives soon.
+func nextEvent(t *testing.T, h *Host) Event {
+	t.Helper()
+	select {
+	case e := <-h.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+// Check that the next two events report the CPU starting at $1000 and
+// then stopping for the reason.
+func expectRun(t *testing.T, h *Host, reason StopReason) Event {
+	t.Helper()
+	if e := nextEvent(t, h); !e.Running || e.PC != 0x1000 {
+		t.Errorf("first event %+v, expected the CPU to start at $1000", e)
+	}
+	e := nextEvent(t, h)
+	if e.Running || e.Reason != reason {
+		t.Errorf("second event %+v, expected the CPU to stop (%v)", e, reason)
+	}
+	if h.Running() {
+		t.Error("CPU is running after it stopped")
+	}
+	if last := h.LastEvent(); last != e {
+		t.Errorf("last event %+v, expected %+v", last, e)
+	}
+	return e
+}
+
+func TestExecutorPause(t *testing.T) {
+	h := newLoopHost()
+	defer h.Cleanup()
+
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(context.Background()); err != ErrRunning {
+		t.Errorf("Start while running returned %v, expected ErrRunning", err)
+	}
+	if err := h.RunUntil(context.Background(), 0x1002); err != ErrRunning {
+		t.Errorf("RunUntil while running returned %v, expected ErrRunning", err)
+	}
+	if err := h.Step(); err != ErrRunning {
+		t.Errorf("Step while running returned %v, expected ErrRunning", err)
+	}
+	h.Pause()
+	expectRun(t, h, StopPaused)
+
+	if err := h.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, h); e.Running || e.Reason != StopStep {
+		t.Errorf("step event %+v", e)
+	}
+}
+
+func TestExecutorRunUntil(t *testing.T) {
+	h := newLoopHost()
+	defer h.Cleanup()
+
+	start := h.cpu.Cycles
+	if err := h.RunUntil(context.Background(), 0x1002); err != nil {
+		t.Fatal(err)
+	}
+	if e := expectRun(t, h, StopTarget); e.PC != 0x1002 || e.Cycles-start != 4 {
+		t.Errorf("stopped at $%04X after %d cycles, expected $1002 after 4", e.PC, e.Cycles-start)
+	}
+}
+
+func TestExecutorCancel(t *testing.T) {
+	h := newLoopHost()
+	defer h.Cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	expectRun(t, h, StopCanceled)
+}
+
+func TestExecutorKeepsLatestEvents(t *testing.T) {
+	h := newLoopHost()
+	defer h.Cleanup()
+
+	for i := 0; i < 2*cap(h.exec.events); i++ {
+		if err := h.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.Pause()
+
+	var e Event
+	for n := len(h.Events()); n > 0; n-- {
+		e = <-h.Events()
+	}
+	if e.Running || e.Reason != StopPaused || e != h.LastEvent() {
+		t.Errorf("last event received %+v, expected the CPU to stop (paused)", e)
+	}
+}
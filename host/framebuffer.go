@@ -0,0 +1,222 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"fmt"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/cmd"
+	"github.com/cjr29/go6502/devices"
+)
+
+// Frames are assumed to be completed 60 times a second when setting the
+// delay between the frames of a recording.
+const frameRate = 60
+
+// A recording collects frames of a framebuffer for an animated GIF.
+type recording struct {
+	device string // name of the framebuffer being recorded
+	path   string // file the animation is saved to
+	every  uint64 // record one of every this many frames
+	start  uint64 // frame count when recording began
+	shown  int    // hundredths of a second of animation recorded so far
+	anim   gif.GIF
+}
+
+// Create a framebuffer, 256x240 pixels at 4 bits per pixel unless another
+// size is given as <width>x<height> or another depth as <bits>bpp.
+func newFramebuffer(h *Host, name string, args []string) (devices.Device, error) {
+	width, height, depth := 256, 240, 4
+	for _, arg := range args {
+		var err error
+		if bits, ok := strings.CutSuffix(strings.ToLower(arg), "bpp"); ok {
+			depth, err = strconv.Atoi(bits)
+			if err != nil || depth != 1 && depth != 2 && depth != 4 && depth != 8 {
+				return nil, fmt.Errorf("invalid pixel depth '%s' (must be 1bpp, 2bpp, 4bpp or 8bpp)", arg)
+			}
+			continue
+		}
+		if width, height, err = parseSize(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	if width > 0x10000 || height > 0x10000 || (width*depth+7)/8*height+3 > 0x10000 {
+		return nil, fmt.Errorf("a %dx%d framebuffer at %d bits per pixel doesn't fit in memory",
+			width, height, depth)
+	}
+
+	f := devices.NewFramebuffer(width, height, depth)
+	f.OnFrame = func(n uint64) { h.recordFrame(name, f, n) }
+	return f, nil
+}
+
+// Return the named framebuffer. If the name is empty, return the first
+// framebuffer.
+func (h *Host) framebuffer(name string) (string, *devices.Framebuffer, error) {
+	for _, m := range h.bus.Mappings() {
+		if f, ok := m.Device.(*devices.Framebuffer); ok && (name == "" || m.Name == name) {
+			return m.Name, f, nil
+		}
+	}
+	switch {
+	case name == "":
+		return "", nil, fmt.Errorf("no framebuffer devices added")
+	case h.bus.Find(name) != nil:
+		return "", nil, fmt.Errorf("device '%s' is not a framebuffer", name)
+	default:
+		return "", nil, fmt.Errorf("device '%s' not found", name)
+	}
+}
+
+// Add a completed frame to the recording if it's one of the frames being
+// recorded.
+func (h *Host) recordFrame(name string, f *devices.Framebuffer, n uint64) {
+	r := h.recording
+	if r == nil || r.device != name || (n-r.start)%r.every != 0 {
+		return
+	}
+
+	// GIF delays are in hundredths of a second, so each frame is shown
+	// until the time its successor would be completed, rounded down. This
+	// keeps the animation in step with the frame rate on average.
+	frames := uint64(len(r.anim.Image) + 1)
+	delay := int(frames*r.every*100/frameRate) - r.shown
+	if delay < 2 {
+		// Many viewers play shorter delays much more slowly.
+		delay = 2
+	}
+	r.shown += delay
+	r.anim.Image = append(r.anim.Image, f.Image())
+	r.anim.Delay = append(r.anim.Delay, delay)
+}
+
+// Stop recording and save the animation, if anything was recorded.
+func (h *Host) stopRecording() {
+	r := h.recording
+	if r == nil {
+		return
+	}
+	h.recording = nil
+
+	if len(r.anim.Image) == 0 {
+		fmt.Fprintf(h, "No frames of '%s' were recorded.\n", r.device)
+		return
+	}
+	if err := writeImage(r.path, func(f *os.File) error { return gif.EncodeAll(f, &r.anim) }); err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return
+	}
+	fmt.Fprintf(h, "Saved %d frames of '%s' to '%s'.\n", len(r.anim.Image), r.device, r.path)
+}
+
+// Create an image file and write to it with the encode function.
+func writeImage(path string, encode func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (h *Host) cmdScreenCapture(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	var name string
+	if len(args) > 1 {
+		name = args[1]
+	}
+	name, f, err := h.framebuffer(name)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	img := f.Image()
+	var encode func(f *os.File) error
+	switch strings.ToLower(filepath.Ext(args[0])) {
+	case ".png":
+		encode = func(f *os.File) error { return png.Encode(f, img) }
+	case ".gif":
+		encode = func(f *os.File) error { return gif.Encode(f, img, nil) }
+	default:
+		fmt.Fprintf(h, "Image file '%s' must be a .png or .gif file.\n", args[0])
+		return nil
+	}
+
+	if err := writeImage(args[0], encode); err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+	fmt.Fprintf(h, "Captured '%s' to '%s'.\n", name, args[0])
+	return nil
+}
+
+func (h *Host) cmdScreenRecord(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		c.DisplayUsage(h)
+		return nil
+	}
+	if !strings.EqualFold(filepath.Ext(args[0]), ".gif") {
+		fmt.Fprintf(h, "Recording file '%s' must be a .gif file.\n", args[0])
+		return nil
+	}
+
+	every := 1
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Fprintf(h, "Invalid frame interval '%s'.\n", args[1])
+			return nil
+		}
+		every = n
+	}
+
+	var name string
+	if len(args) > 2 {
+		name = args[2]
+	}
+	name, f, err := h.framebuffer(name)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	h.stopRecording()
+	h.recording = &recording{
+		device: name,
+		path:   args[0],
+		every:  uint64(every),
+		start:  f.Frames(),
+	}
+	frames := "frame"
+	if every > 1 {
+		frames = strconv.Itoa(every) + " frames"
+	}
+	fmt.Fprintf(h, "Recording every %s of '%s' to '%s'.\n", frames, name, args[0])
+	return nil
+}
+
+func (h *Host) cmdScreenStop(c *cmd.Command, args []string) error {
+	if h.recording == nil {
+		fmt.Fprintln(h, "Not recording.")
+		return nil
+	}
+	h.stopRecording()
+	return nil
+}
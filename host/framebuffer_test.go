@@ -0,0 +1,46 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cjr29/go6502/devices"
+)
+
+func TestFramebufferFits(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+
+	// The registers follow pixel memory, so 64K of pixels doesn't fit.
+	if _, err := newFramebuffer(h, "fb", []string{"256x256", "8bpp"}); err == nil {
+		t.Error("a framebuffer with 64K of pixel memory was created")
+	}
+	if _, err := newFramebuffer(h, "fb", []string{"256x255", "8bpp"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordingDelays(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+	f := devices.NewFramebuffer(8, 8, 1)
+
+	for every, want := range map[uint64][]int{
+		1: {2, 2, 2, 2, 2, 2},
+		2: {3, 3, 4, 3, 3, 4},
+		6: {10, 10, 10, 10, 10, 10},
+	} {
+		h.recording = &recording{device: "fb", every: every}
+		for n := uint64(1); n <= 6*every; n++ {
+			h.recordFrame("fb", f, n)
+		}
+		if got := h.recording.anim.Delay; !reflect.DeepEqual(got, want) {
+			t.Errorf("every %d frames: delays %v, expected %v", every, got, want)
+		}
+	}
+	h.recording = nil
+}
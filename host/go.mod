@@ -7,6 +7,7 @@ require (
 	github.com/beevik/prefixtree v0.3.0
 	github.com/cjr29/go6502/asm v0.0.0-20240520005320-9fb32dbc95b2
 	github.com/cjr29/go6502/cpu v0.0.0-20240520005320-9fb32dbc95b2
+	github.com/cjr29/go6502/devices v0.0.0
 	github.com/cjr29/go6502/disasm v0.0.0-20240520005320-9fb32dbc95b2
 	github.com/cjr29/go6502/term v0.0.0-20240525125723-5dc44534dbc7
 )
@@ -20,3 +21,5 @@ replace github.com/cjr29/go6502/asm v0.0.0 => ./asm
 replace github.com/cjr29/go6502/cpu v0.0.0 => ../cpu
 
 replace github.com/cjr29/go6502/disasm v0.0.0 => ../disasm
+
+replace github.com/cjr29/go6502/devices v0.0.0 => ../devices
@@ -15,6 +15,8 @@ package host
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
@@ -24,12 +26,15 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/beevik/cmd"
 	"github.com/cjr29/go6502/asm"
 	"github.com/cjr29/go6502/cpu"
+	"github.com/cjr29/go6502/devices"
 	"github.com/cjr29/go6502/disasm"
 	"github.com/cjr29/go6502/term"
 )
@@ -62,6 +67,21 @@ type Host struct {
 	theme          *disasm.Theme
 	prompt         string
 	mem            *cpu.FlatMemory
+	bus            *devices.Bus
+	deviceTypes    map[string]string
+	console        *consolePort
+	consoleDevice  string
+	machineName    string
+	clock          clock
+	statusLine     bool
+	screen         *devices.TextDisplay
+	screenDevice   string
+	screenShown    bool
+	screenVersion  uint64
+	screenDrawn    time.Time
+	recording      *recording
+	exec           executor
+	background     bool
 	cpu            *cpu.CPU
 	debugger       *cpu.Debugger
 	lastCmd        *cmd.Command
@@ -73,6 +93,7 @@ type Host struct {
 	exprParser     *exprParser
 	sourceCode     map[string][]string
 	sourceMap      *asm.SourceMap
+	symbols        map[string]asm.Symbol
 	settings       *settings
 	annotations    map[uint16]string
 }
@@ -122,8 +143,11 @@ func New() *Host {
 		exprParser:  newExprParser(),
 		sourceCode:  make(map[string][]string),
 		sourceMap:   asm.NewSourceMap(),
+		symbols:     make(map[string]asm.Symbol),
 		settings:    newSettings(),
 		annotations: make(map[uint16]string),
+		deviceTypes: make(map[string]string),
+		exec:        executor{events: make(chan Event, 64)},
 	}
 
 	// Set up raw terminal callbacks.
@@ -133,9 +157,12 @@ func New() *Host {
 	// Initialize host state.
 	h.setState(stateProcessingCommands)
 
-	// Create the emulated CPU and memory.
+	// Create the emulated CPU and memory. The CPU accesses memory through
+	// a bus so that devices may be mapped into the address space.
 	h.mem = cpu.NewFlatMemory()
-	h.cpu = cpu.NewCPU(cpu.CMOS, h.mem)
+	h.bus = devices.NewBus(h.mem)
+	h.cpu = cpu.NewCPU(cpu.CMOS, h.bus)
+	h.cpu.AttachHardware(h.bus)
 
 	// Create a CPU debugger and attach it to the CPU.
 	h.debugger = cpu.NewDebugger(h)
@@ -149,12 +176,15 @@ func New() *Host {
 
 // Cleanup cleans up all resources initialized by the call to New().
 func (h *Host) Cleanup() {
+	h.Pause()
+	h.stopRecording()
 	h.disableRawMode()
 }
 
-// Reset CPU
+// Reset CPU and devices
 func (h *Host) Reset() {
 	h.cpu.Reg.Init()
+	h.resetDevices()
 }
 
 func (h *Host) enableRawMode() {
@@ -274,6 +304,8 @@ func (h *Host) historyTest(line string) bool {
 // to the host. It returns the number of bytes written.
 func (h *Host) Write(p []byte) (n int, err error) {
 	if h.rawMode {
+		h.clearStatusLine()
+		h.releaseScreen()
 		return h.rawTerminal.Write(p)
 	}
 	if h.output == nil {
@@ -330,7 +362,14 @@ func (h *Host) RestoreIoState(state *IoState) {
 
 // Process command from GUI
 func (h *Host) ProcessGUICmd(line string) {
+	h.Lock()
+	defer h.Unlock()
+
+	// Commands that run the CPU leave it running in the background, so
+	// the GUI stays responsive.
+	h.background = true
 	err = h.processCommand(line)
+	h.background = false
 }
 
 // RunCommands accepts host commands from a reader and outputs the results
@@ -451,12 +490,14 @@ func (h *Host) assembleInline() error {
 
 	fmt.Fprintln(h, "Assembling inline code...")
 	s := strings.Join(h.assembly, "\n")
-	a, _, err := asm.Assemble(strings.NewReader(s), "inline", h.miniAddr, h, 0)
+	var options asm.Option
+	if h.cpu.Arch == cpu.CMOS {
+		options |= asm.CMOS
+	}
+	a, _, err := asm.Assemble(strings.NewReader(s), "inline", h.miniAddr, h, options)
+	a.WriteDiagnostics(h)
 
 	if err != nil {
-		for _, e := range a.Errors {
-			fmt.Fprintln(h, e)
-		}
 		fmt.Fprintln(h, "Assembly failed.")
 		return nil
 	}
@@ -484,6 +525,11 @@ func (h *Host) assembleInline() error {
 
 // Break interrupts a running CPU.
 func (h *Host) Break() {
+	if h.Running() {
+		h.exec.pause.Store(true)
+		return
+	}
+
 	switch h.state {
 	case stateRunning:
 		h.state = stateInterrupted
@@ -518,10 +564,37 @@ func (h *Host) readLine(interactive bool) (string, error) {
 }
 
 func (h *Host) displayPC() {
-	d, _ := disasm.Disassemble(h.cpu, h.cpu.Reg.PC, disasm.ShowFull, "", h.theme)
+	d, _ := disasm.DisassembleWithSymbols(h.cpu, h.cpu.Reg.PC, disasm.ShowFull, "", h.symbolTable(), h.theme)
 	fmt.Fprintln(h, d)
 }
 
+// Build a table of names indexed by address, used to disassemble code
+// symbolically. Source map exports take precedence over imported symbols,
+// and labels over other symbols with the same value. Symbols that aren't
+// labels are used only outside the zero page, since small constants are
+// rarely addresses. When several symbols of the same kind share an
+// address, the first name alphabetically is chosen.
+func (h *Host) symbolTable() disasm.SymbolTable {
+	syms := make(disasm.SymbolTable)
+	labels := make(map[uint16]bool)
+	for _, s := range h.symbols {
+		if s.Value < 0 || s.Value > 0xffff || !s.Label && s.Value < 0x100 {
+			continue
+		}
+		addr := uint16(s.Value)
+		if name, ok := syms[addr]; ok {
+			if labels[addr] && !s.Label || labels[addr] == s.Label && name < s.Name {
+				continue
+			}
+		}
+		syms[addr], labels[addr] = s.Name, s.Label
+	}
+	for _, e := range h.sourceMap.Exports {
+		syms[e.Address] = e.Label
+	}
+	return syms
+}
+
 func (h *Host) cmdAnnotate(c *cmd.Command, args []string) error {
 	if len(args) < 1 {
 		c.DisplayUsage(h)
@@ -562,18 +635,35 @@ func (h *Host) cmdAssembleFile(c *cmd.Command, args []string) error {
 	}
 
 	var options asm.Option
-	if len(args) > 1 {
-		verbose, err := stringToBool(args[1])
+	format := asm.FormatBinary
+	for _, arg := range args[1:] {
+		if verbose, err := stringToBool(arg); err == nil {
+			if verbose {
+				options |= asm.Verbose
+			}
+			continue
+		}
+		f, err := asm.ParseFormat(arg)
 		if err != nil {
 			c.DisplayUsage(h)
 			return nil
 		}
-		if verbose {
-			options |= asm.Verbose
-		}
+		format = f
+	}
+	if h.settings.AsmListing {
+		options |= asm.GenerateListing
+	}
+	if h.settings.AsmZPReport {
+		options |= asm.ReportShortening
+	}
+	if h.settings.AsmRelax {
+		options |= asm.RelaxBranches
+	}
+	if h.settings.AsmSymbols {
+		options |= asm.ExportSymbols
 	}
 
-	err := asm.AssembleFile(path, options, h)
+	err := asm.AssembleFileFormat(path, format, options, h)
 	if err != nil {
 		fmt.Fprintf(h, "Failed to assemble (%v).\n", err)
 	}
@@ -766,6 +856,19 @@ func (h *Host) cmdBreakpointDisable(c *cmd.Command, args []string) error {
 	return nil
 }
 
+func (h *Host) cmdCPUArch(c *cmd.Command, args []string) error {
+	if len(args) > 0 {
+		err := h.SetArch(args[0])
+		if err != nil {
+			fmt.Fprintf(h, "%v\n", err)
+			return nil
+		}
+	}
+
+	fmt.Fprintf(h, "CPU architecture is %s.\n", archName(h.cpu.Arch))
+	return nil
+}
+
 func (h *Host) cmdDataBreakpointList(c *cmd.Command, args []string) error {
 	bp := h.debugger.GetDataBreakpoints()
 	if len(bp) == 0 {
@@ -891,7 +994,6 @@ func (h *Host) cmdDisassemble(c *cmd.Command, args []string) error {
 	if len(args) == 0 {
 		args = []string{"$"}
 	}
-
 	addr, err := h.parseAddr(args[0], h.settings.NextDisasmAddr)
 	if err != nil {
 		fmt.Fprintf(h, "%v\n", err)
@@ -908,8 +1010,9 @@ func (h *Host) cmdDisassemble(c *cmd.Command, args []string) error {
 		count = int(l)
 	}
 
+	syms := h.symbolTable()
 	for i := 0; i < count; i++ {
-		d, next := disasm.Disassemble(h.cpu, addr, disasm.ShowBasic, h.annotations[addr], h.theme)
+		d, next := disasm.DisassembleWithSymbols(h.cpu, addr, disasm.ShowBasic, h.annotations[addr], syms, h.theme)
 		fmt.Fprintln(h, d)
 		addr = next
 	}
@@ -932,6 +1035,61 @@ func (h *Host) cmdExports(c *cmd.Command, args []string) error {
 	return nil
 }
 
+func (h *Host) cmdSymbolsLoad(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	filename := args[0]
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	symbols, err := asm.ReadSymbols(data)
+	if err != nil {
+		fmt.Fprintf(h, "Failed to load symbols from '%s' (%v).\n", filepath.Base(filename), err)
+		return nil
+	}
+
+	labels := len(args) > 1 && strings.ToLower(args[1]) == "labels"
+	for _, s := range symbols {
+		s.Label = s.Label || labels
+		h.symbols[strings.ToLower(s.Name)] = s
+	}
+	fmt.Fprintf(h, "Loaded %d symbols from '%s'.\n", len(symbols), filepath.Base(filename))
+	return nil
+}
+
+func (h *Host) cmdSymbolsList(c *cmd.Command, args []string) error {
+	if len(h.symbols) == 0 {
+		fmt.Fprintln(h, "No symbols loaded.")
+		return nil
+	}
+
+	symbols := make([]asm.Symbol, 0, len(h.symbols))
+	for _, s := range h.symbols {
+		symbols = append(symbols, s)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Name < symbols[j].Name
+	})
+
+	fmt.Fprintln(h, "Loaded symbols:")
+	for _, s := range symbols {
+		fmt.Fprintf(h, "   %-16s $%04X\n", s.Name, s.Value)
+	}
+	return nil
+}
+
+func (h *Host) cmdSymbolsClear(c *cmd.Command, args []string) error {
+	h.symbols = make(map[string]asm.Symbol)
+	fmt.Fprintln(h, "Symbols cleared.")
+	return nil
+}
+
 func (h *Host) cmdEvaluate(c *cmd.Command, args []string) error {
 	if len(args) < 1 {
 		c.DisplayUsage(h)
@@ -955,17 +1113,24 @@ func (h *Host) cmdExecute(c *cmd.Command, args []string) error {
 		return nil
 	}
 
-	file, err := os.Open(args[0])
+	err := h.executeFile(args[0])
 	if err != nil {
 		fmt.Fprintf(h, "%v\n", err)
-		return nil
+	}
+	return nil
+}
+
+// Run the host commands contained in a script file.
+func (h *Host) executeFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
 	}
 	defer file.Close()
 
 	ioState := h.EnableProcessedMode(file, os.Stdout)
 	h.RunCommands(false)
 	h.RestoreIoState(ioState)
-
 	return nil
 }
 
@@ -1155,7 +1320,7 @@ func (h *Host) cmdMemorySet(c *cmd.Command, args []string) error {
 			fmt.Fprintf(h, "%v\n", err)
 			return nil
 		}
-		h.mem.StoreByte(addr, byte(v))
+		h.bus.StoreByte(addr, byte(v))
 		addr++
 	}
 
@@ -1198,6 +1363,111 @@ func (h *Host) cmdMemoryCopy(c *cmd.Command, args []string) error {
 	return nil
 }
 
+func (h *Host) cmdMemoryExport(c *cmd.Command, args []string) error {
+	if len(args) < 3 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	filename := args[0]
+	start, err := h.parseExpr(args[1])
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	end, err := h.parseExpr(args[2])
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	var entries []uint16
+	for _, arg := range args[3:] {
+		entry, err := h.parseExpr(arg)
+		if err != nil {
+			fmt.Fprintf(h, "%v\n", err)
+			return nil
+		}
+		entries = append(entries, entry)
+	}
+
+	// Export to a buffer first, so a failed export leaves no file behind.
+	var buf bytes.Buffer
+	err = disasm.Export(&buf, h.cpu, start, end, entries)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	err = os.WriteFile(filename, buf.Bytes(), 0600)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Exported $%04X..$%04X to '%s'.\n", start, end, filepath.Base(filename))
+	return nil
+}
+
+func (h *Host) cmdMemorySave(c *cmd.Command, args []string) error {
+	if len(args) < 3 {
+		c.DisplayUsage(h)
+		return nil
+	}
+
+	filename := args[0]
+
+	addr0, err := h.parseAddr(args[1], 0)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	addr1, err := h.parseAddr(args[2], 0)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	if addr1 < addr0 {
+		fmt.Fprintln(h, "End address must be greater than begin address.")
+		return nil
+	}
+
+	format := asm.FormatBinary
+	if len(args) >= 4 {
+		format, err = asm.ParseFormat(args[3])
+	} else if ext := filepath.Ext(filename); ext != "" {
+		if f, err := asm.ParseFormat(ext[1:]); err == nil {
+			format = f
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	b := make([]byte, int(addr1)-int(addr0)+1)
+	h.cpu.Mem.LoadBytes(addr0, b)
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+	defer file.Close()
+
+	_, err = asm.WriteImage(file, format, []asm.Region{{Address: addr0, Code: b}})
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Saved $%04X..$%04X to '%s' (%s).\n", addr0, addr1, filepath.Base(filename), format)
+	return nil
+}
+
 func (h *Host) cmdQuit(c *cmd.Command, args []string) error {
 	return errors.New("exiting program")
 }
@@ -1289,6 +1559,11 @@ func (h *Host) cmdRegister(c *cmd.Command, args []string) error {
 }
 
 func (h *Host) cmdRun(c *cmd.Command, args []string) error {
+	if h.Running() {
+		fmt.Fprintf(h, "%v\n", ErrRunning)
+		return nil
+	}
+
 	if len(args) > 0 {
 		pc, err := h.parseExpr(args[0])
 		if err != nil {
@@ -1298,20 +1573,28 @@ func (h *Host) cmdRun(c *cmd.Command, args []string) error {
 		h.cpu.SetPC(pc)
 	}
 
-	fmt.Fprintf(h, "Running from $%04X. Press ctrl-C to break.\n", h.cpu.Reg.PC)
+	// Commands from the GUI are processed with the host locked, and the
+	// CPU is left running in the background.
+	if h.background {
+		fmt.Fprintf(h, "Running from $%04X at %s in the background.\n", h.cpu.Reg.PC, h.clockDesc())
+		h.start(context.Background(), -1)
+		return nil
+	}
 
-	h.state = stateRunning
-	for step := 0; h.state == stateRunning; step++ {
-		h.step()
-		h.breakCheck(step)
+	fmt.Fprintf(h, "Running from $%04X at %s. Press ctrl-C to break.\n", h.cpu.Reg.PC, h.clockDesc())
+
+	h.Lock()
+	err := h.start(context.Background(), -1)
+	h.Unlock()
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
 	}
 
-	if h.state == stateInterrupted {
+	switch h.wait(h.checkCtrlC) {
+	case StopPaused, StopBRK:
 		h.displayPC()
 	}
-
-	h.setState(stateProcessingCommands)
-	h.settings.NextDisasmAddr = h.cpu.Reg.PC
 	return nil
 }
 
@@ -1319,22 +1602,27 @@ func (h *Host) breakCheck(step int) {
 	// To prevent performance degradation, only test for ctrl-C once every 128
 	// CPU steps.
 	if (step & 127) == 127 {
-		// Peek at the console's input buffer to see if it contains a key-down
-		// event for ctrl-C. This is only necessary on Windows, where there is
-		// no ability to detect a break signal. On all other platforms,
-		// term.PeekKey() is a no-op that returns false.
-		const CtrlC rune = 3
-		if h.rawMode && term.PeekKey(int(os.Stdin.Fd()), CtrlC) {
-			// If ctrl-C was detected, flush the input buffer by reading lines
-			// until the ctrl-C is encountered.
-			for {
-				_, err := h.rawTerminal.ReadLine()
-				if err == io.EOF {
-					break
-				}
+		h.checkCtrlC()
+	}
+}
+
+// Check for a ctrl-C typed while the CPU is running.
+func (h *Host) checkCtrlC() {
+	// Peek at the console's input buffer to see if it contains a key-down
+	// event for ctrl-C. This is only necessary on Windows, where there is
+	// no ability to detect a break signal. On all other platforms,
+	// term.PeekKey() is a no-op that returns false.
+	const CtrlC rune = 3
+	if h.rawMode && term.PeekKey(int(os.Stdin.Fd()), CtrlC) {
+		// If ctrl-C was detected, flush the input buffer by reading lines
+		// until the ctrl-C is encountered.
+		for {
+			_, err := h.rawTerminal.ReadLine()
+			if err == io.EOF {
+				break
 			}
-			h.Break()
 		}
+		h.Break()
 	}
 }
 
@@ -1384,6 +1672,11 @@ func (h *Host) cmdSet(c *cmd.Command, args []string) error {
 }
 
 func (h *Host) cmdStepIn(c *cmd.Command, args []string) error {
+	if h.Running() {
+		fmt.Fprintf(h, "%v\n", ErrRunning)
+		return nil
+	}
+
 	// Parse the number of steps.
 	count := 1
 	if len(args) > 0 {
@@ -1414,6 +1707,11 @@ func (h *Host) cmdStepIn(c *cmd.Command, args []string) error {
 }
 
 func (h *Host) cmdStepOver(c *cmd.Command, args []string) error {
+	if h.Running() {
+		fmt.Fprintf(h, "%v\n", ErrRunning)
+		return nil
+	}
+
 	// Parse the number of steps.
 	count := 1
 	if len(args) > 0 {
@@ -1444,6 +1742,11 @@ func (h *Host) cmdStepOver(c *cmd.Command, args []string) error {
 }
 
 func (h *Host) cmdStepOut(c *cmd.Command, args []string) error {
+	if h.Running() {
+		fmt.Fprintf(h, "%v\n", ErrRunning)
+		return nil
+	}
+
 	count := 1
 
 	h.setState(stateRunning)
@@ -1484,39 +1787,28 @@ func (h *Host) load(binFilename string, addr int) (origin uint16, err error) {
 	}
 	defer binFile.Close()
 
-	a := &asm.Assembly{}
-	_, err = a.ReadFrom(binFile)
+	data, err := ioutil.ReadAll(binFile)
 	if err != nil {
 		fmt.Fprintf(h, "%v\n", err)
 		return 0, nil
 	}
 
-	// Try loading a source map file if it exists.
-	mapFilename := binFilename[:len(binFilename)-len(ext)] + ".map"
-	mapFile, err := os.Open(mapFilename)
-	var sourceMap *asm.SourceMap
-	if err == nil {
-		defer mapFile.Close()
-		sourceMap = asm.NewSourceMap()
-		_, err = sourceMap.ReadFrom(mapFile)
-		if err != nil {
-			fmt.Fprintf(h, "Failed to read source map '%s': %v\n", filepath.Base(mapFilename), err)
-			sourceMap = nil
-		} else {
-			if crc32.ChecksumIEEE(a.Code) == sourceMap.CRC {
-				fmt.Fprintf(h, "Loaded source map from '%s'.\n", filepath.Base(mapFilename))
-				if len(h.sourceMap.Files) == 0 {
-					h.sourceMap = sourceMap
-				} else {
-					h.sourceMap.Merge(sourceMap)
-				}
-			} else {
-				fmt.Fprintf(h, "Source map CRC doesn't match for '%s'.\n", filepath.Base(binFilename))
-				sourceMap = nil
-			}
-		}
+	// Files containing address information don't need a source map or an
+	// origin address.
+	format := asm.DetectFormat(data, ext)
+	if format != asm.FormatBinary {
+		return h.loadImage(binFilename, data, format, addr)
 	}
 
+	a := &asm.Assembly{}
+	_, err = a.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return 0, nil
+	}
+
+	sourceMap := h.loadSourceMap(binFilename, a.Code)
+
 	// Set the origin address using either the value from the source map file
 	// or the value passed to this function.
 	originSet := false
@@ -1539,6 +1831,83 @@ func (h *Host) load(binFilename string, addr int) (origin uint16, err error) {
 	return origin, nil
 }
 
+// Load an Intel HEX, S-record or PRG image into memory. Each region is
+// stored at the address recorded in the file. A PRG file may be relocated
+// by passing an address other than -1.
+func (h *Host) loadImage(filename string, data []byte, format asm.Format, addr int) (origin uint16, err error) {
+	regions, err := asm.ReadImage(data, format)
+	if err != nil {
+		fmt.Fprintf(h, "Failed to read %s file '%s': %v\n", format, filepath.Base(filename), err)
+		return 0, nil
+	}
+	if len(regions) == 0 {
+		fmt.Fprintf(h, "File '%s' contains no data.\n", filepath.Base(filename))
+		return 0, nil
+	}
+	if format == asm.FormatPRG && addr != -1 {
+		regions[0].Address = uint16(addr)
+	}
+	for _, r := range regions {
+		if int(r.Address)+len(r.Code) > 0x10000 {
+			fmt.Fprintf(h, "File '%s' region at $%04X extends beyond $FFFF.\n",
+				filepath.Base(filename), r.Address)
+			return 0, nil
+		}
+	}
+
+	var code []byte
+	for _, r := range regions {
+		code = append(code, r.Code...)
+	}
+	h.loadSourceMap(filename, code)
+
+	check := "record checksums valid"
+	if format == asm.FormatPRG {
+		check = "no checksum"
+	}
+
+	for _, r := range regions {
+		h.cpu.Mem.StoreBytes(r.Address, r.Code)
+		fmt.Fprintf(h, "Loaded '%s' region $%04X..$%04X (%s, CRC $%08X).\n",
+			filepath.Base(filename), r.Address, int(r.Address)+len(r.Code)-1,
+			check, crc32.ChecksumIEEE(r.Code))
+	}
+
+	origin = regions[0].Address
+	h.settings.NextDisasmAddr = origin
+	return origin, nil
+}
+
+// Load the source map associated with a machine code file if it exists
+// and its CRC matches the code.
+func (h *Host) loadSourceMap(filename string, code []byte) *asm.SourceMap {
+	mapFilename := filename[:len(filename)-len(filepath.Ext(filename))] + ".map"
+	mapFile, err := os.Open(mapFilename)
+	if err != nil {
+		return nil
+	}
+	defer mapFile.Close()
+
+	sourceMap := asm.NewSourceMap()
+	_, err = sourceMap.ReadFrom(mapFile)
+	if err != nil {
+		fmt.Fprintf(h, "Failed to read source map '%s': %v\n", filepath.Base(mapFilename), err)
+		return nil
+	}
+	if crc32.ChecksumIEEE(code) != sourceMap.CRC {
+		fmt.Fprintf(h, "Source map CRC doesn't match for '%s'.\n", filepath.Base(filename))
+		return nil
+	}
+
+	fmt.Fprintf(h, "Loaded source map from '%s'.\n", filepath.Base(mapFilename))
+	if len(h.sourceMap.Files) == 0 {
+		h.sourceMap = sourceMap
+	} else {
+		h.sourceMap.Merge(sourceMap)
+	}
+	return sourceMap
+}
+
 func (h *Host) step() {
 	h.cpu.Step()
 }
@@ -1587,6 +1956,7 @@ func (h *Host) stepOut() {
 
 func (h *Host) onSettingsUpdate() {
 	h.exprParser.hexMode = h.settings.HexMode
+	h.updateDeviceClocks()
 }
 
 func (h *Host) parseAddr(s string, next uint16) (uint16, error) {
@@ -1629,7 +1999,7 @@ func (h *Host) dumpMemory(addr0, bytes uint16) {
 	if addr1-addr0 < 8 {
 		addrToBuf(addr0, buf[0:4])
 		for a, c1, c2 := uint32(addr0), 6, 32; a <= uint32(addr1); a, c1, c2 = a+1, c1+3, c2+1 {
-			m := h.cpu.Mem.LoadByte(uint16(a))
+			m := h.bus.PeekByte(uint16(a))
 			byteToBuf(m, buf[c1:c1+2])
 			buf[c2] = toPrintableChar(m)
 		}
@@ -1649,7 +2019,7 @@ func (h *Host) dumpMemory(addr0, bytes uint16) {
 		addrToBuf(a, buf[0:4])
 		for c1, c2 := 6, 32; c1 < 29; c1, c2, a = c1+3, c2+1, a+1 {
 			if a >= addr0 && a <= addr1 {
-				m := h.cpu.Mem.LoadByte(a)
+				m := h.bus.PeekByte(a)
 				byteToBuf(m, buf[c1:c1+2])
 				buf[c2] = toPrintableChar(m)
 			} else {
@@ -1711,6 +2081,10 @@ func (h *Host) resolveIdentifier(s string) (int64, error) {
 		}
 	}
 
+	if sym, ok := h.symbols[s]; ok {
+		return int64(sym.Value), nil
+	}
+
 	return 0, fmt.Errorf("identifier '%s' not found", s)
 }
 
@@ -1734,7 +2108,7 @@ func (h *Host) OnDataBreakpoint(cpu *cpu.CPU, b *cpu.DataBreakpoint) {
 	h.setState(stateBreakpoint)
 
 	if cpu.LastPC != cpu.Reg.PC {
-		d, _ := disasm.Disassemble(h.cpu, cpu.LastPC, disasm.ShowFull, "", h.theme)
+		d, _ := disasm.DisassembleWithSymbols(h.cpu, cpu.LastPC, disasm.ShowFull, "", h.symbolTable(), h.theme)
 		fmt.Fprintln(h, d)
 	}
 
@@ -0,0 +1,56 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cjr29/go6502/asm"
+)
+
+func TestSymbolTable(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+	for _, s := range []asm.Symbol{
+		{Name: "COUNT", Value: 3},
+		{Name: "PTR", Value: 0x80, Label: true},
+		{Name: "START", Value: 0x1000, Label: true},
+		{Name: "BEGIN", Value: 0x1000, Label: true},
+		{Name: "SIZE", Value: 0x1000},
+		{Name: "CHROUT", Value: 0xffd2},
+		{Name: "ECHO", Value: 0xffef},
+		{Name: "WOZ", Value: 0xffef},
+		{Name: "HUGE", Value: 0x12345},
+	} {
+		h.symbols[s.Name] = s
+	}
+
+	syms := h.symbolTable()
+	if len(syms) != 4 || syms[0x80] != "PTR" || syms[0x1000] != "BEGIN" ||
+		syms[0xffd2] != "CHROUT" || syms[0xffef] != "ECHO" {
+		t.Errorf("unexpected symbol table %v", syms)
+	}
+}
+
+func TestSymbolsLoadLabels(t *testing.T) {
+	h := New()
+	defer h.Cleanup()
+	filename := filepath.Join(t.TempDir(), "wozmon.sym")
+	if err := os.WriteFile(filename, []byte("XAML = $24\nECHO = $FFEF\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h.cmdSymbolsLoad(nil, []string{filename})
+	if syms := h.symbolTable(); len(syms) != 1 || syms[0xffef] != "ECHO" {
+		t.Errorf("unexpected symbol table %v", syms)
+	}
+
+	h.cmdSymbolsLoad(nil, []string{filename, "labels"})
+	if syms := h.symbolTable(); len(syms) != 2 || syms[0x24] != "XAML" || syms[0xffef] != "ECHO" {
+		t.Errorf("unexpected symbol table %v with labels", syms)
+	}
+}
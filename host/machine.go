@@ -0,0 +1,270 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/beevik/cmd"
+	"github.com/cjr29/go6502/devices"
+)
+
+// A machine describes a complete emulated system that may be set up with
+// the "machine" command.
+type machine struct {
+	brief string
+	usage string
+	setup func(h *Host, args []string) error
+}
+
+// All machines, indexed by name.
+var machines = map[string]machine{
+	"apple1": {
+		brief: "Apple-1 with WozMon at $FF00 and its terminal at $D010",
+		usage: "apple1 <rom-file>",
+		setup: (*Host).setupApple1,
+	},
+}
+
+// LoadMachine sets up the named machine, replacing all devices and
+// resetting the CPU. The arguments are specific to the machine. If the name
+// isn't that of a built-in machine, it is the filename of a JSON or TOML
+// machine configuration.
+func (h *Host) LoadMachine(name string, args ...string) error {
+	m, ok := machines[strings.ToLower(name)]
+	if !ok {
+		_, err := os.Stat(name)
+		ext := strings.ToLower(filepath.Ext(name))
+		if err != nil && ext != ".json" && ext != ".toml" {
+			return fmt.Errorf("unknown machine '%s'", name)
+		}
+		return h.loadMachineConfig(name)
+	}
+	if err := m.setup(h, args); err != nil {
+		return err
+	}
+	h.machineName = strings.ToLower(name)
+	return nil
+}
+
+// Remove all devices from the address space.
+func (h *Host) removeDevices() {
+	for _, m := range append([]*devices.Mapping(nil), h.bus.Mappings()...) {
+		h.bus.Unmap(m.Name)
+		h.closeDevice(m.Name, m.Device)
+		delete(h.deviceTypes, m.Name)
+	}
+}
+
+// A deviceSet holds a machine's devices along with the host state that
+// refers to them. A new machine is built in a set of its own, which
+// replaces the host's devices only once it is complete.
+type deviceSet struct {
+	bus           *devices.Bus
+	types         map[string]string
+	console       *consolePort
+	consoleDevice string
+	screen        *devices.TextDisplay
+	screenDevice  string
+	screenShown   bool
+	recording     *recording
+}
+
+// Exchange the host's devices with those of the set.
+func (h *Host) swapDevices(s *deviceSet) {
+	h.bus, s.bus = s.bus, h.bus
+	h.deviceTypes, s.types = s.types, h.deviceTypes
+	h.console, s.console = s.console, h.console
+	h.consoleDevice, s.consoleDevice = s.consoleDevice, h.consoleDevice
+	h.screen, s.screen = s.screen, h.screen
+	h.screenDevice, s.screenDevice = s.screenDevice, h.screenDevice
+	h.screenShown, s.screenShown = s.screenShown, h.screenShown
+	h.recording, s.recording = s.recording, h.recording
+}
+
+// Map the devices of a set, which were built on a bus of their own, into
+// the host's address space once its own devices have been removed.
+func (h *Host) installDevices(s *deviceSet) {
+	for _, m := range s.bus.Mappings() {
+		n, err := h.bus.Map(m.Name, m.Addr, m.Size, m.Device)
+		if err != nil {
+			panic(err) // the set's bus accepted the same mappings
+		}
+		n.Line = m.Line
+		h.deviceTypes[m.Name] = s.types[m.Name]
+	}
+	h.console, h.consoleDevice = s.console, s.consoleDevice
+	h.screen, h.screenDevice, h.screenShown = s.screen, s.screenDevice, s.screenShown
+}
+
+// Set up an Apple-1: WozMon in ROM at $FF00, and a PIA at $D010 connecting
+// the keyboard and display to the console.
+func (h *Host) setupApple1(args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing WozMon ROM filename")
+	}
+
+	rom, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if len(rom) == 0 || len(rom) > 0x100 {
+		return fmt.Errorf("ROM image '%s' must be 1 to 256 bytes long", args[0])
+	}
+
+	h.removeDevices()
+	h.mem.StoreBytes(0xff00, rom)
+
+	h.console = newConsolePort()
+	h.consoleDevice = "pia"
+	t := newApple1Terminal(h.console)
+	m, err := h.bus.Map("pia", 0xd010, 0, t)
+	if err != nil {
+		h.closeDevice("pia", t)
+		return err
+	}
+	m.Line = devices.LineNone
+	h.deviceTypes[m.Name] = "pia"
+
+	h.resetDevices()
+	h.cpu.Reset()
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	return nil
+}
+
+// Offset of KBDCR ($D011), the PIA's control register A.
+const apple1KBDCR = 1
+
+// An apple1Terminal is the Apple-1's PIA together with the keyboard and
+// display wired to it. Keys arrive on port A with bit 7 set, strobed by
+// CA1. Characters written to port B are displayed when CB2 signals them,
+// and the display acknowledges each one on CB1. PB7 reads low to show the
+// display is always ready.
+type apple1Terminal struct {
+	*devices.PIA
+	port *consolePort
+	mu   sync.Mutex
+	keys []byte
+}
+
+func newApple1Terminal(port *consolePort) *apple1Terminal {
+	t := &apple1Terminal{PIA: devices.NewPIA(), port: port}
+	t.SetPortB(0x00)
+	t.OnCB2 = func(hi bool) {
+		if !hi {
+			t.display(t.PortB() & 0x7f)
+			t.SetCB1(false)
+			t.SetCB1(true)
+		}
+	}
+
+	go func() {
+		var buf [64]byte
+		for {
+			n, err := port.Read(buf[:])
+			t.mu.Lock()
+			for _, b := range buf[:n] {
+				t.keys = append(t.keys, apple1Key(b))
+			}
+			t.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Translate a console key into the code the Apple-1 keyboard produces. The
+// keyboard has no lower case, and WozMon uses the underscore to rub out.
+func apple1Key(b byte) byte {
+	switch {
+	case b == '\n':
+		b = '\r'
+	case b == 0x7f || b == 0x08:
+		b = '_'
+	case b >= 'a' && b <= 'z':
+		b -= 'a' - 'A'
+	}
+	return b | 0x80
+}
+
+// Show a character on the console the way the Apple-1 display would.
+func (t *apple1Terminal) display(c byte) {
+	switch {
+	case c == '\r':
+		t.port.Write([]byte("\r\n"))
+	case c >= 0x20 && c < 0x60:
+		t.port.Write([]byte{c})
+	}
+}
+
+// Update presents the next key once the previous one has been read.
+func (t *apple1Terminal) Update(cycles uint64) {
+	t.PIA.Update(cycles)
+	if t.Peek(apple1KBDCR)&0x80 != 0 {
+		return // the CA1 flag shows the last key is still unread
+	}
+
+	t.mu.Lock()
+	if len(t.keys) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	k := t.keys[0]
+	t.keys = t.keys[1:]
+	t.mu.Unlock()
+
+	// Strobe CA1 with the edge the PIA is programmed to detect.
+	rising := t.Peek(apple1KBDCR)&0x02 != 0
+	t.SetPortA(k)
+	t.SetCA1(!rising)
+	t.SetCA1(rising)
+}
+
+// Connection returns the name of the terminal's connection.
+func (t *apple1Terminal) Connection() string {
+	return "console"
+}
+
+// Close disconnects the terminal from the console.
+func (t *apple1Terminal) Close() error {
+	return t.port.Close()
+}
+
+func (h *Host) cmdMachine(c *cmd.Command, args []string) error {
+	if len(args) < 1 {
+		fmt.Fprintln(h, "Machines:")
+		names := make([]string, 0, len(machines))
+		for n := range machines {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(h, "   %-24s %s\n", machines[n].usage, machines[n].brief)
+		}
+		fmt.Fprintf(h, "   %-24s %s\n", "<file>.json", "machine described by a configuration file")
+		return nil
+	}
+
+	err := h.LoadMachine(args[0], args[1:]...)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	fmt.Fprintf(h, "Machine '%s' ready. Reset to $%04X.\n", h.machineName, h.cpu.Reg.PC)
+	if h.console != nil {
+		fmt.Fprintf(h, "Device '%s' uses the console while the CPU runs.\n", h.consoleDevice)
+	}
+	return nil
+}
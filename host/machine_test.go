@@ -0,0 +1,86 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Apple-1 PIA registers, as offsets from $D010.
+const (
+	apple1KBD   = 0
+	apple1DSP   = 2
+	apple1DSPCR = 3
+)
+
+// Create a terminal with its PIA programmed the way WozMon programs it.
+func newTestApple1Terminal() (*apple1Terminal, *bytes.Buffer) {
+	var out bytes.Buffer
+	port := newConsolePort()
+	port.out = &out
+	t := newApple1Terminal(port)
+	t.Write(apple1DSP, 0x7f)
+	t.Write(apple1KBDCR, 0xa7)
+	t.Write(apple1DSPCR, 0xa7)
+	return t, &out
+}
+
+// Update the terminal until a key is strobed into the PIA.
+func waitForKey(t *testing.T, term *apple1Terminal) byte {
+	deadline := time.Now().Add(5 * time.Second)
+	for term.Peek(apple1KBDCR)&0x80 == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no key was strobed")
+		}
+		term.Update(1)
+		time.Sleep(time.Millisecond)
+	}
+	return term.Read(apple1KBD)
+}
+
+func TestApple1Keyboard(t *testing.T) {
+	term, _ := newTestApple1Terminal()
+	defer term.Close()
+
+	go term.port.w.Write([]byte("a\x7f\n"))
+	for _, want := range []byte{'A' | 0x80, '_' | 0x80, '\r' | 0x80} {
+		if k := waitForKey(t, term); k != want {
+			t.Errorf("key $%02X, expected $%02X", k, want)
+		}
+		if term.Peek(apple1KBDCR)&0x80 != 0 {
+			t.Error("reading the key didn't clear the CA1 flag")
+		}
+	}
+
+	for in, want := range map[byte]byte{'z': 0xda, 0x08: 0xdf, '1': 0xb1, 'Q': 0xd1} {
+		if k := apple1Key(in); k != want {
+			t.Errorf("key $%02X translated to $%02X, expected $%02X", in, k, want)
+		}
+	}
+}
+
+func TestApple1Display(t *testing.T) {
+	term, out := newTestApple1Terminal()
+	defer term.Close()
+
+	if term.Read(apple1DSP)&0x80 != 0 {
+		t.Error("PB7 doesn't show the display is ready")
+	}
+
+	for _, c := range []byte("HI\r") {
+		term.Write(apple1DSP, c|0x80)
+		if !term.CB2() {
+			t.Errorf("CB2 is still low after displaying $%02X", c)
+		}
+		if term.Read(apple1DSP)&0x80 != 0 {
+			t.Errorf("PB7 doesn't show the display is ready after $%02X", c)
+		}
+	}
+	if got := out.String(); got != "HI\r\n" {
+		t.Errorf("displayed %q, expected %q", got, "HI\r\n")
+	}
+}
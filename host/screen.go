@@ -0,0 +1,195 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/cmd"
+	"github.com/cjr29/go6502/devices"
+)
+
+// Minimum interval between redraws of the text display shown on the
+// console.
+const screenInterval = 40 * time.Millisecond
+
+// A TextScreen is a snapshot of the screen of a text display device.
+type TextScreen struct {
+	Name          string   // name of the display device
+	Lines         []string // text of each row
+	CursorCol     int      // cursor column
+	CursorRow     int      // cursor row
+	CursorVisible bool     // the cursor is showing
+	Version       uint64   // changes whenever the screen does
+}
+
+// TextScreen returns a snapshot of the text display shown on the console,
+// or of the first text display if none is. It returns false if there are
+// no text displays.
+func (h *Host) TextScreen() (TextScreen, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	name, d, err := h.textDisplay("")
+	if err != nil {
+		return TextScreen{}, false
+	}
+	col, row, visible := d.Cursor()
+	return TextScreen{
+		Name:          name,
+		Lines:         d.Lines(),
+		CursorCol:     col,
+		CursorRow:     row,
+		CursorVisible: visible,
+		Version:       d.Version(),
+	}, true
+}
+
+// Create a text display, 40 columns by 25 rows unless another size is
+// given as <columns>x<rows>. A display given the "console" argument is
+// drawn on the terminal while the CPU runs.
+func newTextDisplay(h *Host, name string, args []string) (devices.Device, error) {
+	cols, rows := 40, 25
+	console := false
+	for _, arg := range args {
+		if strings.ToLower(arg) == "console" {
+			console = true
+			continue
+		}
+		var err error
+		if cols, rows, err = parseSize(arg); err != nil {
+			return nil, err
+		}
+		if cols > 255 || rows > 255 {
+			return nil, fmt.Errorf("display size %s exceeds 255x255", arg)
+		}
+	}
+
+	if console && h.screen != nil {
+		return nil, fmt.Errorf("console is already showing display '%s'", h.screenDevice)
+	}
+
+	d := devices.NewTextDisplay(cols, rows)
+	if console {
+		h.screen, h.screenDevice = d, name
+	}
+	return d, nil
+}
+
+// Parse a size of the form <width>x<height>.
+func parseSize(s string) (w, h int, err error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if ok {
+		w, err = strconv.Atoi(ws)
+		if err == nil {
+			h, err = strconv.Atoi(hs)
+		}
+	}
+	if !ok || err != nil || w < 1 || h < 1 {
+		return 0, 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return w, h, nil
+}
+
+// Return the named text display. If the name is empty, return the display
+// shown on the console, or the first text display if none is.
+func (h *Host) textDisplay(name string) (string, *devices.TextDisplay, error) {
+	if name == "" && h.screen != nil {
+		return h.screenDevice, h.screen, nil
+	}
+	for _, m := range h.bus.Mappings() {
+		if d, ok := m.Device.(*devices.TextDisplay); ok && (name == "" || m.Name == name) {
+			return m.Name, d, nil
+		}
+	}
+	switch {
+	case name == "":
+		return "", nil, fmt.Errorf("no text display devices added")
+	case h.bus.Find(name) != nil:
+		return "", nil, fmt.Errorf("device '%s' is not a text display", name)
+	default:
+		return "", nil, fmt.Errorf("device '%s' not found", name)
+	}
+}
+
+// Return the lines of a text display's screen surrounded by a border.
+func screenLines(d *devices.TextDisplay) []string {
+	edge := "+" + strings.Repeat("-", d.Columns()) + "+"
+	lines := []string{edge}
+	for _, l := range d.Lines() {
+		lines = append(lines, "|"+l+"|")
+	}
+	return append(lines, edge)
+}
+
+// Redraw the text display shown on the console if it has changed and
+// enough time has passed since it was last drawn. The display is drawn
+// over the whole terminal, starting at its top left corner.
+func (h *Host) updateScreen(force bool) {
+	d := h.screen
+	if !h.rawMode || d == nil || d.Version() == h.screenVersion && h.screenShown {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(h.screenDrawn) < screenInterval {
+		return
+	}
+
+	var b bytes.Buffer
+	if !h.screenShown {
+		b.WriteString("\x1b[H\x1b[2J")
+	}
+	col, row, visible := d.Cursor()
+	for i, l := range screenLines(d) {
+		fmt.Fprintf(&b, "\x1b[%d;1H", i+1)
+		if visible && i == row+1 {
+			// Show the cursor in inverse video.
+			fmt.Fprintf(&b, "%s\x1b[7m%c\x1b[27m%s", l[:col+1], l[col+1], l[col+2:])
+		} else {
+			b.WriteString(l)
+		}
+	}
+	h.rawTerminal.Write(b.Bytes())
+
+	h.screenShown = true
+	h.screenVersion = d.Version()
+	h.screenDrawn = now
+}
+
+// Bring the text display shown on the console up to date and move the
+// terminal's cursor below it, so other output doesn't overwrite it.
+func (h *Host) releaseScreen() {
+	if h.screenShown && h.screen != nil {
+		h.updateScreen(true)
+		h.screenShown = false
+		fmt.Fprintf(h.rawTerminal, "\x1b[%d;1H", h.screen.Rows()+3)
+	}
+}
+
+func (h *Host) cmdScreenShow(c *cmd.Command, args []string) error {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	_, d, err := h.textDisplay(name)
+	if err != nil {
+		fmt.Fprintf(h, "%v\n", err)
+		return nil
+	}
+
+	for _, l := range screenLines(d) {
+		fmt.Fprintln(h, l)
+	}
+	col, row, visible := d.Cursor()
+	if visible {
+		fmt.Fprintf(h, "Cursor at column %d, row %d.\n", col, row)
+	}
+	return nil
+}
@@ -24,6 +24,12 @@ type settings struct {
 	NextDisasmAddr  uint16 `doc:"address of next disassembly"`
 	NextSourceAddr  uint16 `doc:"address of next source line display"`
 	NextMemDumpAddr uint16 `doc:"address of next memory dump"`
+	AsmListing      bool   `doc:"generate a listing file when assembling"`
+	AsmZPReport     bool   `doc:"report operands shortened to zero-page addressing"`
+	AsmRelax        bool   `doc:"expand out-of-range branches when assembling"`
+	AsmSymbols      bool   `doc:"write symbol files for other tools when assembling"`
+	ClockHz         int    `doc:"CPU clock rate in Hz to pace execution at (0 = full speed)"`
+	Turbo           bool   `doc:"run at full speed regardless of ClockHz"`
 }
 
 func newSettings() *settings {
@@ -36,6 +42,12 @@ func newSettings() *settings {
 		MaxStepLines:    20,
 		NextDisasmAddr:  0,
 		NextMemDumpAddr: 0,
+		AsmListing:      false,
+		AsmZPReport:     false,
+		AsmRelax:        false,
+		AsmSymbols:      false,
+		ClockHz:         0,
+		Turbo:           false,
 	}
 }
 
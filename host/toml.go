@@ -0,0 +1,308 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A tomlParser reads the subset of TOML used by machine configuration
+// files: key/value pairs with string, integer, float, boolean, array and
+// inline table values, [table] headers and [[array]] table headers. Dotted
+// keys, multi-line strings and dates aren't supported.
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+// Convert a TOML document into the equivalent JSON, so that it may be
+// decoded like a JSON configuration file.
+func tomlToJSON(data []byte) ([]byte, error) {
+	p := &tomlParser{s: string(data), line: 1}
+	doc, err := p.document()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.line, err)
+	}
+	return json.Marshal(doc)
+}
+
+// Parse the whole document into a map of top-level keys.
+func (p *tomlParser) document() (map[string]any, error) {
+	root := make(map[string]any)
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.pos == len(p.s) {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			var err error
+			if table, err = p.header(root); err != nil {
+				return nil, err
+			}
+		} else {
+			key, v, err := p.keyValue()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := table[key]; ok {
+				return nil, fmt.Errorf("duplicate key '%s'", key)
+			}
+			table[key] = v
+		}
+
+		p.skipSpace(false)
+		if p.pos < len(p.s) && p.peek() != '\n' && p.peek() != '\r' {
+			return nil, fmt.Errorf("unexpected '%c'", p.peek())
+		}
+	}
+}
+
+// Parse a [table] or [[array]] header and return the table that the
+// following keys belong to.
+func (p *tomlParser) header(root map[string]any) (map[string]any, error) {
+	array := strings.HasPrefix(p.s[p.pos:], "[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	p.skipSpace(false)
+	name, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(false)
+
+	end := "]"
+	if array {
+		end = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.pos:], end) {
+		return nil, fmt.Errorf("missing '%s' after table name", end)
+	}
+	p.pos += len(end)
+
+	table := make(map[string]any)
+	switch v := root[name].(type) {
+	case nil:
+		if array {
+			root[name] = []any{table}
+		} else {
+			root[name] = table
+		}
+	case []any:
+		if !array {
+			return nil, fmt.Errorf("duplicate key '%s'", name)
+		}
+		root[name] = append(v, table)
+	default:
+		return nil, fmt.Errorf("duplicate key '%s'", name)
+	}
+	return table, nil
+}
+
+// Parse a "key = value" pair.
+func (p *tomlParser) keyValue() (string, any, error) {
+	key, err := p.key()
+	if err != nil {
+		return "", nil, err
+	}
+	p.skipSpace(false)
+	if p.pos == len(p.s) || p.peek() != '=' {
+		return "", nil, fmt.Errorf("missing '=' after key '%s'", key)
+	}
+	p.pos++
+	p.skipSpace(false)
+	v, err := p.value()
+	return key, v, err
+}
+
+// Parse a bare or quoted key.
+func (p *tomlParser) key() (string, error) {
+	if p.pos < len(p.s) && (p.peek() == '"' || p.peek() == '\'') {
+		return p.str()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && isBareKeyChar(p.peek()) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("missing key")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// Parse a value of any supported type.
+func (p *tomlParser) value() (any, error) {
+	if p.pos == len(p.s) {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && (isBareKeyChar(p.peek()) || strings.IndexByte("+.:", p.peek()) >= 0) {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, fmt.Errorf("unexpected '%c'", p.peek())
+	}
+
+	num := strings.ReplaceAll(tok, "_", "")
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(num, prefix) {
+			if v, err := strconv.ParseInt(num[2:], base, 64); err == nil {
+				return v, nil
+			}
+			return nil, fmt.Errorf("invalid number '%s'", tok)
+		}
+	}
+	if v, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(num, 64); err == nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid value '%s'", tok)
+}
+
+// Parse a basic ("...") or literal ('...') string on a single line.
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	end := p.pos + 1
+	for ; end < len(p.s) && p.s[end] != quote && p.s[end] != '\n'; end++ {
+		if quote == '"' && p.s[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(p.s) || p.s[end] != quote {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	s := p.s[p.pos+1 : end]
+	p.pos = end + 1
+	if quote == '\'' {
+		return s, nil
+	}
+	v, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return "", fmt.Errorf("invalid string \"%s\"", s)
+	}
+	return v, nil
+}
+
+// Parse an array, which may span several lines.
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++
+	a := []any{}
+	for {
+		p.skipSpace(true)
+		if p.pos == len(p.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+
+		p.skipSpace(true)
+		switch {
+		case p.pos == len(p.s):
+			return nil, fmt.Errorf("unterminated array")
+		case p.peek() == ',':
+			p.pos++
+		case p.peek() != ']':
+			return nil, fmt.Errorf("missing ',' between array values")
+		}
+	}
+}
+
+// Parse an inline table, which must be on a single line.
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++
+	t := make(map[string]any)
+	p.skipSpace(false)
+	if p.pos < len(p.s) && p.peek() == '}' {
+		p.pos++
+		return t, nil
+	}
+	for {
+		p.skipSpace(false)
+		key, v, err := p.keyValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := t[key]; ok {
+			return nil, fmt.Errorf("duplicate key '%s'", key)
+		}
+		t[key] = v
+
+		p.skipSpace(false)
+		switch {
+		case p.pos == len(p.s):
+			return nil, fmt.Errorf("unterminated inline table")
+		case p.peek() == ',':
+			p.pos++
+		case p.peek() == '}':
+			p.pos++
+			return t, nil
+		default:
+			return nil, fmt.Errorf("missing ',' between inline table values")
+		}
+	}
+}
+
+// Skip spaces, tabs and comments, and also line breaks if newlines is
+// true.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.s) {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.s) && p.peek() != '\n' {
+				p.pos++
+			}
+		case newlines && (c == '\n' || c == '\r'):
+			if c == '\n' {
+				p.line++
+			}
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) peek() byte {
+	return p.s[p.pos]
+}
@@ -21,6 +21,14 @@ import (
 
 var (
 	assemble   string
+	listing    bool
+	format     string
+	jsonDiag   bool
+	symbols    bool
+	relax      bool
+	arch       string
+	machine    string
+	rom        string
 	gui        bool
 	logFile    *os.File
 	err        error
@@ -39,6 +47,14 @@ func init() {
 
 	// Initialize the startup parameters to be parsed in command line
 	flag.StringVar(&assemble, "a", "", "assemble file")
+	flag.BoolVar(&listing, "l", false, "generate listing file when assembling")
+	flag.StringVar(&format, "f", "bin", "output format when assembling (bin, hex, s19, s28, prg)")
+	flag.BoolVar(&jsonDiag, "json", false, "report assembly errors and warnings as JSON")
+	flag.BoolVar(&symbols, "s", false, "write VICE, plain and ld65 symbol files when assembling")
+	flag.BoolVar(&relax, "relax", false, "expand out-of-range branches when assembling")
+	flag.StringVar(&arch, "arch", "", "CPU architecture (6502/nmos or 65c02/cmos)")
+	flag.StringVar(&machine, "machine", "", "set up a machine (apple1 or a JSON or TOML machine configuration file)")
+	flag.StringVar(&rom, "rom", "", "ROM image file for the machine")
 	flag.BoolVar(&gui, "g", false, "Activate GUI")
 	flag.CommandLine.Usage = func() {
 		fmt.Println("Usage: go6502 [script] ..\nOptions:")
@@ -70,13 +86,47 @@ func main() {
 
 	// Initiate assembly from the command line if requested.
 	if assemble != "" {
-		err := asm.AssembleFile(assemble, 0, os.Stdout)
+		var options asm.Option
+		if listing {
+			options |= asm.GenerateListing
+		}
+		if jsonDiag {
+			options |= asm.JSONDiagnostics
+		}
+		if symbols {
+			options |= asm.ExportSymbols
+		}
+		if relax {
+			options |= asm.RelaxBranches
+		}
+		f, err := asm.ParseFormat(format)
+		if err == nil {
+			err = asm.AssembleFileFormat(assemble, f, options, os.Stdout)
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to assemble (%v).\n", err)
 		}
 		os.Exit(0)
 	}
 
+	// Select the requested CPU architecture.
+	if arch != "" {
+		if err := h.SetArch(arch); err != nil {
+			exitOnError(err)
+		}
+	}
+
+	// Set up the requested machine.
+	if machine != "" {
+		var args []string
+		if rom != "" {
+			args = append(args, rom)
+		}
+		if err := h.LoadMachine(machine, args...); err != nil {
+			exitOnError(err)
+		}
+	}
+
 	// Run commands contained in command-line files.
 	args := flag.Args()
 	if len(args) > 0 {
@@ -52,6 +52,12 @@ func PeekKey(fd int, key rune) bool {
 	return peekKey(fd, key)
 }
 
+// InputReady returns true if input is waiting to be read from the given
+// file descriptor, so that reading it won't block.
+func InputReady(fd int) bool {
+	return inputReady(fd)
+}
+
 // Restore restores the terminal connected to the given file descriptor to a
 // previous state.
 func Restore(fd int, oldState *State) error {
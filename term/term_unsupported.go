@@ -34,6 +34,10 @@ func peekKey(fd int, key rune) bool {
 	return false
 }
 
+func inputReady(fd int) bool {
+	return false
+}
+
 func getState(fd int) (*State, error) {
 	return nil, fmt.Errorf("terminal: GetState not implemented on %s/%s", runtime.GOOS, runtime.GOARCH)
 }
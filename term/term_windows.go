@@ -128,6 +128,11 @@ func peekKey(fd int, key rune) bool {
 	return false
 }
 
+func inputReady(fd int) bool {
+	event, err := windows.WaitForSingleObject(windows.Handle(fd), 0)
+	return err == nil && event == windows.WAIT_OBJECT_0
+}
+
 func getState(fd int) (*State, error) {
 	var st uint32
 	if err := windows.GetConsoleMode(windows.Handle(fd), &st); err != nil {